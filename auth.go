@@ -0,0 +1,164 @@
+package bblfsh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// defaultTokenType is an authorization scheme used for tokens without an explicit type.
+	defaultTokenType = "Bearer"
+
+	// authorizationHeader is a metadata key used to send tokens.
+	authorizationHeader = "authorization"
+)
+
+// Token is an access token used to authenticate requests to bblfshd.
+type Token struct {
+	// Value is the token itself.
+	Value string
+	// Type is the authorization scheme. Defaults to "Bearer".
+	Type string
+	// Expiry is the time when the token expires. Zero value means it never expires.
+	Expiry time.Time
+}
+
+// expired checks if the token expires in less than the given duration.
+func (t *Token) expired(early time.Duration) bool {
+	if t.Expiry.IsZero() {
+		return false
+	}
+	return !time.Now().Add(early).Before(t.Expiry)
+}
+
+// TokenSource provides tokens for authentication. It is called for every RPC,
+// thus implementations that fetch tokens remotely should be wrapped with ReuseTokenSource.
+//
+// Implementations must be safe for concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenSourceFunc is a function that implements TokenSource.
+type TokenSourceFunc func(ctx context.Context) (*Token, error)
+
+// Token implements TokenSource.
+func (f TokenSourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a token source that always returns the same bearer token.
+func StaticToken(token string) TokenSource {
+	t := &Token{Value: token}
+	return TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		return t, nil
+	})
+}
+
+// ReuseTokenSource returns a token source that caches tokens returned by src and
+// refreshes them the given duration before they expire.
+func ReuseTokenSource(src TokenSource, early time.Duration) TokenSource {
+	return &reuseTokenSource{src: src, early: early}
+}
+
+type reuseTokenSource struct {
+	src   TokenSource
+	early time.Duration
+
+	mu  sync.Mutex
+	tok *Token
+}
+
+// Token implements TokenSource.
+func (s *reuseTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok != nil && !s.tok.expired(s.early) {
+		return s.tok, nil
+	}
+	tok, err := s.src.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// tokenCredentials implements credentials.PerRPCCredentials for a token source.
+type tokenCredentials struct {
+	src TokenSource
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	tok, err := c.src.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	typ := tok.Type
+	if typ == "" {
+		typ = defaultTokenType
+	}
+	return map[string]string{authorizationHeader: typ + " " + tok.Value}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// headerCredentials implements credentials.PerRPCCredentials for a static header.
+type headerCredentials struct {
+	header, value string
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (c headerCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	return map[string]string{c.header: c.value}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (c headerCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// hostCallOptions is a DriverHostClient that adds call options to each RPC.
+type hostCallOptions struct {
+	h    protocol2.DriverHostClient
+	opts []grpc.CallOption
+}
+
+// withHostCallOptions wraps a host client to add call options to each RPC.
+func withHostCallOptions(h protocol2.DriverHostClient, opts []grpc.CallOption) protocol2.DriverHostClient {
+	if len(opts) == 0 {
+		return h
+	}
+	return &hostCallOptions{h: h, opts: opts}
+}
+
+// ServerVersion implements protocol2.DriverHostClient.
+func (c *hostCallOptions) ServerVersion(
+	ctx context.Context,
+	in *protocol2.VersionRequest,
+	opts ...grpc.CallOption) (*protocol2.VersionResponse, error) {
+	return c.h.ServerVersion(ctx, in, append(c.opts[:len(c.opts):len(c.opts)], opts...)...)
+}
+
+// SupportedLanguages implements protocol2.DriverHostClient.
+func (c *hostCallOptions) SupportedLanguages(
+	ctx context.Context,
+	in *protocol2.SupportedLanguagesRequest,
+	opts ...grpc.CallOption) (*protocol2.SupportedLanguagesResponse, error) {
+	return c.h.SupportedLanguages(ctx, in, append(c.opts[:len(c.opts):len(c.opts)], opts...)...)
+}
+
+var (
+	_ credentials.PerRPCCredentials = tokenCredentials{}
+	_ credentials.PerRPCCredentials = headerCredentials{}
+)
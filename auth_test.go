@@ -0,0 +1,129 @@
+package bblfsh
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authInterceptor rejects RPCs without an expected metadata value and records the called methods.
+type authInterceptor struct {
+	header, value string
+
+	mu      sync.Mutex
+	methods []string
+}
+
+func (a *authInterceptor) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(a.header); len(v) != 1 || v[0] != a.value {
+		return nil, status.Errorf(codes.Unauthenticated, "unexpected %s: %q", a.header, v)
+	}
+	a.mu.Lock()
+	a.methods = append(a.methods, info.FullMethod)
+	a.mu.Unlock()
+	return h(ctx, req)
+}
+
+func (a *authInterceptor) calls() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string{}, a.methods...)
+}
+
+func TestTokenSource(t *testing.T) {
+	auth := &authInterceptor{header: "authorization", value: "Bearer secret"}
+	addr, stop := newMockServer(t, &mockServer{}, grpc.UnaryInterceptor(auth.intercept))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// without the token, the client fails to check the server version
+	_, err := NewClientContext(ctx, addr)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithTokenSource(StaticToken("secret")))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Content("foo").UAST()
+	require.NoError(t, err)
+	_, err = cli.NewVersionRequest().Do()
+	require.NoError(t, err)
+	_, err = cli.NewSupportedLanguagesRequest().DoV2()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"/gopkg.in.bblfsh.sdk.v2.protocol.DriverHost/ServerVersion",
+		"/gopkg.in.bblfsh.sdk.v2.protocol.Driver/Parse",
+		"/gopkg.in.bblfsh.sdk.v2.protocol.DriverHost/ServerVersion",
+		"/gopkg.in.bblfsh.sdk.v2.protocol.DriverHost/SupportedLanguages",
+	}, auth.calls())
+}
+
+func TestAPIKey_MultipleConnections(t *testing.T) {
+	auth := &authInterceptor{header: "x-api-key", value: "secret"}
+	addr, stop := newMockServer(t, &mockServer{}, grpc.UnaryInterceptor(auth.intercept))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cli, err := NewClientWithOptionsContext(ctx, "python="+addr+",go="+addr, WithAPIKey("x-api-key", "secret"))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Context(ctx).Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	_, err = cli.NewParseRequest().Context(ctx).Language("go").Content("foo").Do()
+	require.NoError(t, err)
+
+	// connections dialed by a custom function are authenticated as well
+	cli2, err := NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
+		return grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	}, WithAPIKey("x-api-key", "secret"))
+	require.NoError(t, err)
+	defer cli2.Close()
+
+	_, err = cli2.NewParseRequest().Context(ctx).Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	require.Len(t, auth.calls(), 3)
+}
+
+func TestReuseTokenSource(t *testing.T) {
+	var calls int32
+	src := ReuseTokenSource(TokenSourceFunc(func(ctx context.Context) (*Token, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return &Token{
+			Value:  string('a' + rune(n-1)),
+			Type:   "Token",
+			Expiry: time.Now().Add(50 * time.Millisecond),
+		}, nil
+	}), 10*time.Millisecond)
+	creds := tokenCredentials{src: src}
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"authorization": "Token a"}, md)
+
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Token a", md["authorization"])
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	time.Sleep(50 * time.Millisecond)
+	md, err = creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "Token b", md["authorization"])
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
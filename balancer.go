@@ -0,0 +1,137 @@
+package bblfsh
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultMaxFailures  = 5
+	defaultEjectionTime = 30 * time.Second
+)
+
+// BalancePolicy selects a replica for each request.
+type BalancePolicy int
+
+const (
+	// RoundRobin sends requests to replicas in turn.
+	RoundRobin BalancePolicy = iota
+	// LeastOutstanding sends requests to the replica with the least number of requests in flight.
+	LeastOutstanding
+)
+
+// LoadBalancing configures how requests are balanced between replicas of an endpoint.
+//
+// A replica that returns Unavailable for MaxFailures requests in a row is ejected:
+// no requests are sent to it for EjectionTime. If all the replicas are ejected,
+// requests are balanced between all of them.
+//
+// Requests for a language are sent as soon as any of its replicas is connected. Replicas that
+// are down at that time are kept and reconnected in the background.
+type LoadBalancing struct {
+	// Policy selects a replica for each request. Defaults to RoundRobin.
+	Policy BalancePolicy
+	// MaxFailures is the number of consecutive Unavailable errors after which a replica
+	// is ejected. Defaults to 5. Negative value disables ejection.
+	MaxFailures int
+	// EjectionTime is the time a replica stays ejected. Defaults to 30 seconds.
+	EjectionTime time.Duration
+}
+
+func (lb LoadBalancing) withDefaults() LoadBalancing {
+	if lb.MaxFailures == 0 {
+		lb.MaxFailures = defaultMaxFailures
+	}
+	if lb.EjectionTime <= 0 {
+		lb.EjectionTime = defaultEjectionTime
+	}
+	return lb
+}
+
+// replica is a connection to one of the backends serving a language.
+type replica struct {
+	// accessed atomically; must go first to be aligned on 32 bit platforms
+	outstanding int64
+	failures    int64
+	// ejectedUntil is a time in Unix nanoseconds
+	ejectedUntil int64
+
+	conn   *grpc.ClientConn
+	driver protocol2.DriverClient
+	// used only by the health checker
+	health replicaHealth
+}
+
+func newReplica(conn *grpc.ClientConn) *replica {
+	return &replica{
+		conn:   conn,
+		driver: protocol2.NewDriverClient(conn),
+		health: replicaHealth{state: conn.GetState()},
+	}
+}
+
+// ejected checks if the replica is ejected at a given time.
+func (r *replica) ejected(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&r.ejectedUntil)
+}
+
+// eject stops sending requests to the replica for a given duration.
+func (r *replica) eject(d time.Duration) {
+	atomic.StoreInt64(&r.ejectedUntil, time.Now().Add(d).UnixNano())
+	atomic.StoreInt64(&r.failures, 0)
+}
+
+// observe records the result of a request and ejects the replica if it failed too many times in a row.
+func (r *replica) observe(lb LoadBalancing, err error) {
+	if status.Code(err) != codes.Unavailable {
+		atomic.StoreInt64(&r.failures, 0)
+		return
+	}
+	if lb.MaxFailures > 0 && atomic.AddInt64(&r.failures, 1) >= int64(lb.MaxFailures) {
+		r.eject(lb.EjectionTime)
+	}
+}
+
+// parse sends a parse request to the replica, tracking the number of outstanding requests.
+func (r *replica) parse(ctx context.Context, lb LoadBalancing, in *protocol2.ParseRequest, opts ...grpc.CallOption) (*protocol2.ParseResponse, error) {
+	atomic.AddInt64(&r.outstanding, 1)
+	defer atomic.AddInt64(&r.outstanding, -1)
+	resp, err := r.driver.Parse(ctx, in, opts...)
+	r.observe(lb, err)
+	return resp, err
+}
+
+// pick selects a replica for the next request according to the balancing policy.
+func (connD *connDriver) pick(lb LoadBalancing) *replica {
+	reps := connD.replicas
+	if len(reps) == 1 {
+		return reps[0]
+	}
+	now := time.Now()
+	start := int(atomic.AddUint32(&connD.next, 1) % uint32(len(reps)))
+	var best *replica
+	for i := range reps {
+		r := reps[(start+i)%len(reps)]
+		if r.ejected(now) {
+			continue
+		}
+		if lb.Policy != LeastOutstanding {
+			return r
+		}
+		if best == nil || atomic.LoadInt64(&r.outstanding) < atomic.LoadInt64(&best.outstanding) {
+			best = r
+		}
+	}
+	if best == nil {
+		// all the replicas are ejected; ignore ejection
+		best = reps[start]
+	}
+	return best
+}
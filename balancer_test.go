@@ -0,0 +1,186 @@
+package bblfsh
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countingMockServer returns a mock server that counts parse requests and returns a given error.
+func countingMockServer(calls *int32, err error) *mockServer {
+	return &mockServer{parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+		atomic.AddInt32(calls, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+	}}
+}
+
+func newReplicaClient(t testing.TB, endpoint string, options ...ClientOption) *Client {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conf, err := ParseEndpoint(endpoint)
+	require.NoError(t, err)
+	cli, err := NewClientWithEndpointsContext(ctx, conf, options...)
+	require.NoError(t, err)
+	return cli
+}
+
+func TestLoadBalancing_RoundRobin(t *testing.T) {
+	var calls1, calls2 int32
+	addr1, stop1 := newMockServer(t, countingMockServer(&calls1, nil))
+	defer stop1()
+	addr2, stop2 := newMockServer(t, countingMockServer(&calls2, nil))
+	defer stop2()
+
+	cli := newReplicaClient(t, "python="+addr1+"|"+addr2)
+	defer cli.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := cli.NewParseRequest().Language("python").Content("foo").Do()
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(5), atomic.LoadInt32(&calls1))
+	require.Equal(t, int32(5), atomic.LoadInt32(&calls2))
+}
+
+func TestLoadBalancing_LeastOutstanding(t *testing.T) {
+	var calls2 int32
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	addr1, stop1 := newMockServer(t, &mockServer{parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+		started <- struct{}{}
+		<-block
+		return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+	}})
+	defer stop1()
+	addr2, stop2 := newMockServer(t, countingMockServer(&calls2, nil))
+	defer stop2()
+
+	cli := newReplicaClient(t, "python="+addr1+"|"+addr2, WithLoadBalancing(LoadBalancing{Policy: LeastOutstanding}))
+	defer cli.Close()
+
+	// send requests until one of them is blocked on the first replica
+	done := make(chan error, 1)
+	for blocked := false; !blocked; {
+		go func() {
+			_, err := cli.NewParseRequest().Language("python").Content("foo").Do()
+			done <- err
+		}()
+		select {
+		case <-started:
+			blocked = true
+		case err := <-done:
+			require.NoError(t, err)
+		}
+	}
+
+	before := atomic.LoadInt32(&calls2)
+	for i := 0; i < 10; i++ {
+		_, err := cli.NewParseRequest().Language("python").Content("foo").Do()
+		require.NoError(t, err)
+	}
+	require.Equal(t, before+10, atomic.LoadInt32(&calls2))
+
+	close(block)
+	require.NoError(t, <-done)
+}
+
+func TestLoadBalancing_Ejection(t *testing.T) {
+	var calls1, calls2 int32
+	addr1, stop1 := newMockServer(t, countingMockServer(&calls1, status.Error(codes.Unavailable, "overloaded")))
+	defer stop1()
+	addr2, stop2 := newMockServer(t, countingMockServer(&calls2, nil))
+	defer stop2()
+
+	cli := newReplicaClient(t, "python="+addr1+"|"+addr2, WithLoadBalancing(LoadBalancing{
+		MaxFailures:  2,
+		EjectionTime: time.Hour,
+	}))
+	defer cli.Close()
+
+	failed := 0
+	for i := 0; i < 20; i++ {
+		_, err := cli.NewParseRequest().Language("python").Content("foo").Do()
+		if err != nil {
+			require.Equal(t, codes.Unavailable, status.Code(err))
+			failed++
+		}
+	}
+	require.Equal(t, 2, failed)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls1))
+	require.Equal(t, int32(18), atomic.LoadInt32(&calls2))
+}
+
+func TestLoadBalancing_AllEjected(t *testing.T) {
+	r1, r2 := &replica{}, &replica{}
+	connD := &connDriver{replicas: []*replica{r1, r2}}
+	r1.eject(time.Hour)
+	require.Equal(t, r2, connD.pick(LoadBalancing{}))
+	require.Equal(t, r2, connD.pick(LoadBalancing{}))
+
+	r2.eject(time.Hour)
+	picked := map[*replica]bool{}
+	for i := 0; i < 2; i++ {
+		picked[connD.pick(LoadBalancing{})] = true
+	}
+	require.Len(t, picked, 2)
+}
+
+func TestLoadBalancing_DeadReplica(t *testing.T) {
+	var calls1, calls2 int32
+	addr2, stop2 := newMockServer(t, countingMockServer(&calls2, nil))
+	defer stop2()
+
+	// reserve an address for a replica that is down during the dial
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr1 := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	cli := newReplicaClient(t, "python="+addr1+"|"+addr2, WithLoadBalancing(LoadBalancing{
+		MaxFailures:  1,
+		EjectionTime: 10 * time.Millisecond,
+	}))
+	defer cli.Close()
+
+	// the first request must not wait for the dead replica
+	start := time.Now()
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	if err != nil {
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	}
+	require.True(t, time.Since(start) < defaultConnTimeout/2, "%v", time.Since(start))
+
+	dc := cli.driver2.(*multipleDriverClient)
+	dc.mu.Lock()
+	replicas := len(dc.drivers["python"].replicas)
+	dc.mu.Unlock()
+	require.Equal(t, 2, replicas)
+
+	// the replica is reconnected once it's up
+	lis, err = net.Listen("tcp", addr1)
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	protocol2.RegisterDriverServer(srv, countingMockServer(&calls1, nil))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&calls1) == 0 && time.Now().Before(deadline) {
+		_, _ = cli.NewParseRequest().Language("python").Content("foo").Do()
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(t, atomic.LoadInt32(&calls1) > 0)
+}
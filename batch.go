@@ -0,0 +1,174 @@
+package bblfsh
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// defaultBatchConcurrency is a default number of in-flight parse requests for a batch.
+	defaultBatchConcurrency = 8
+)
+
+// BatchItem is a single file to parse in a batch request.
+type BatchItem struct {
+	// Path is a local path of the file. If set and Content is empty, the file is read from disk.
+	Path string
+	// Filename is the name of the file. Defaults to the base name of the Path.
+	Filename string
+	// Language of the file. If empty, the language is detected by the client or the server,
+	// see WithLanguageDetection.
+	Language string
+	// Content is the source code to parse.
+	Content string
+}
+
+// BatchResult is a result of parsing a single BatchItem.
+type BatchResult struct {
+	// Item is the item that was parsed.
+	Item BatchItem
+	// Node is the decoded UAST. It may be set even if Err is not nil, see ParseRequest.UAST.
+	Node Node
+	// Language is the language of the file.
+	Language string
+	// Skipped is the reason the file was skipped by a filter, see BatchRequest.Filters.
+	Skipped string
+	// Err is an error returned for this item. It's a *ParseError, see ParseRequest.UAST.
+	Err error
+}
+
+// BatchRequest is a request to parse multiple files concurrently.
+type BatchRequest struct {
+	ctx         context.Context
+	client      *Client
+	mode        Mode
+	concurrency int
+	filters     []Filter
+}
+
+// NewBatchRequest is a request to parse multiple files concurrently.
+func (c *Client) NewBatchRequest() *BatchRequest {
+	return &BatchRequest{ctx: context.Background(), client: c, concurrency: defaultBatchConcurrency}
+}
+
+// Context sets a cancellation context for the whole batch.
+func (r *BatchRequest) Context(ctx context.Context) *BatchRequest {
+	r.ctx = ctx
+	return r
+}
+
+// Mode controls the level of transformation applied to UAST of all files in the batch.
+func (r *BatchRequest) Mode(mode Mode) *BatchRequest {
+	r.mode = mode
+	return r
+}
+
+// Concurrency sets the maximal number of in-flight parse requests.
+// Values less than one reset it to the default.
+func (r *BatchRequest) Concurrency(n int) *BatchRequest {
+	if n <= 0 {
+		n = defaultBatchConcurrency
+	}
+	r.concurrency = n
+	return r
+}
+
+// Filters adds filters that are checked for each file in the batch, in addition to the client
+// filters. Skipped files are reported with BatchResult.Skipped.
+func (r *BatchRequest) Filters(filters ...Filter) *BatchRequest {
+	r.filters = append(r.filters, filters...)
+	return r
+}
+
+// Do starts parsing items and returns a channel with the results. Results are not ordered.
+//
+// The channel is closed when the items channel is closed and all the items are parsed,
+// or when the batch context is cancelled. In the latter case, the remaining items are
+// not consumed, thus the producer should watch the same context.
+func (r *BatchRequest) Do(items <-chan BatchItem) <-chan BatchResult {
+	return r.run(r.ctx, items)
+}
+
+// Each is the same as Do, but calls fn for each result. Calls to fn are never concurrent.
+//
+// If fn returns an error, the batch is cancelled and the error is returned.
+// If the batch context is cancelled, its error is returned.
+func (r *BatchRequest) Each(items <-chan BatchItem, fn func(res BatchResult) error) error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	var last error
+	for res := range r.run(ctx, items) {
+		if last != nil {
+			continue // draining
+		}
+		if err := fn(res); err != nil {
+			last = err
+			cancel()
+		}
+	}
+	if last != nil {
+		return last
+	}
+	return r.ctx.Err()
+}
+
+func (r *BatchRequest) run(ctx context.Context, items <-chan BatchItem) <-chan BatchResult {
+	n := r.concurrency
+	if n <= 0 {
+		n = defaultBatchConcurrency
+	}
+	out := make(chan BatchResult, n)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				var (
+					it BatchItem
+					ok bool
+				)
+				select {
+				case <-ctx.Done():
+					return
+				case it, ok = <-items:
+					if !ok {
+						return
+					}
+				}
+				res := r.parse(ctx, it)
+				select {
+				case <-ctx.Done():
+					return
+				case out <- res:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func (r *BatchRequest) parse(ctx context.Context, it BatchItem) BatchResult {
+	req := r.client.NewParseRequest().Context(ctx).
+		Language(it.Language).Mode(r.mode).Filters(r.filters...)
+	if it.Content == "" && it.Path != "" {
+		req = req.ReadFile(it.Path)
+	} else {
+		req = req.Content(it.Content)
+	}
+	if it.Filename != "" {
+		req = req.Filename(it.Filename)
+	}
+	req.path = it.Path
+	res, err := req.parse()
+	if err != nil {
+		err = req.parseError(res, err)
+	}
+	return BatchResult{Item: it, Node: res.Node, Language: res.Language, Skipped: res.Skipped, Err: err}
+}
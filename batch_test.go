@@ -0,0 +1,185 @@
+package bblfsh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func newMockClient(t testing.TB, s *mockServer) (*Client, func()) {
+	addr, stop := newMockServer(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientContext(ctx, addr)
+	require.NoError(t, err)
+	return cli, func() {
+		cli.Close()
+		stop()
+	}
+}
+
+func batchItems(items ...BatchItem) <-chan BatchItem {
+	ch := make(chan BatchItem, len(items))
+	for _, it := range items {
+		ch <- it
+	}
+	close(ch)
+	return ch
+}
+
+func TestBatchRequest(t *testing.T) {
+	var cur, max int32
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			n := atomic.AddInt32(&cur, 1)
+			defer atomic.AddInt32(&cur, -1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			resp := &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}
+			if req.Content == "bad" {
+				resp.Errors = []*protocol2.ParseError{{Text: "unexpected token"}}
+			}
+			return resp, nil
+		},
+	})
+	defer stop()
+
+	var items []BatchItem
+	for i := 0; i < 20; i++ {
+		items = append(items, BatchItem{
+			Filename: fmt.Sprintf("file%d.py", i),
+			Language: "python",
+			Content:  "import foo",
+		})
+	}
+	items = append(items, BatchItem{Filename: "bad.py", Language: "python", Content: "bad"})
+
+	results := make(map[string]BatchResult)
+	for res := range cli.NewBatchRequest().Concurrency(3).Do(batchItems(items...)) {
+		results[res.Item.Filename] = res
+	}
+	require.Len(t, results, len(items))
+	require.True(t, atomic.LoadInt32(&max) <= 3, "max in-flight: %d", max)
+
+	for name, res := range results {
+		if name == "bad.py" {
+			require.True(t, ErrSyntax.Is(parseErrCause(t, res.Err)), "%v", res.Err)
+			continue
+		}
+		require.NoError(t, res.Err, name)
+		require.NotNil(t, res.Node, name)
+		require.Equal(t, "python", res.Language, name)
+	}
+}
+
+func TestBatchRequest_Filters(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{})
+	defer stop()
+
+	results := make(map[string]BatchResult)
+	err := cli.NewBatchRequest().Filters(SkipVendored()).Each(batchItems(
+		BatchItem{Path: "vendor/foo/foo.py", Language: "python", Content: "import foo"},
+		BatchItem{Path: "foo.py", Language: "python", Content: "import foo"},
+	), func(res BatchResult) error {
+		results[res.Item.Path] = res
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	res := results["vendor/foo/foo.py"]
+	require.NoError(t, res.Err)
+	require.Equal(t, "vendored file", res.Skipped)
+	require.Nil(t, res.Node)
+
+	res = results["foo.py"]
+	require.NoError(t, res.Err)
+	require.Empty(t, res.Skipped)
+	require.NotNil(t, res.Node)
+}
+
+func TestBatchRequest_ReadFileError(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{})
+	defer stop()
+
+	var res []BatchResult
+	for r := range cli.NewBatchRequest().Do(batchItems(BatchItem{Path: "NO_EXISTS"})) {
+		res = append(res, r)
+	}
+	require.Len(t, res, 1)
+	require.Error(t, res[0].Err)
+}
+
+func TestBatchRequest_EachError(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{})
+	defer stop()
+
+	// the producer never closes the channel; the batch must stop regardless
+	items := make(chan BatchItem)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case items <- BatchItem{Language: "python", Content: "import foo"}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	errStop := errors.New("stop")
+	var calls int
+	err := cli.NewBatchRequest().Concurrency(2).Each(items, func(res BatchResult) error {
+		require.NoError(t, res.Err)
+		calls++
+		if calls == 5 {
+			return errStop
+		}
+		return nil
+	})
+	require.Equal(t, errStop, err)
+	require.Equal(t, 5, calls)
+}
+
+func TestBatchRequest_Cancel(t *testing.T) {
+	var once sync.Once
+	started := make(chan struct{})
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			once.Do(func() { close(started) })
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	items := make(chan BatchItem, 1)
+	items <- BatchItem{Language: "python", Content: "import foo"}
+
+	out := cli.NewBatchRequest().Context(ctx).Do(items)
+	<-started
+	cancel()
+
+	select {
+	case <-out:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not cancelled")
+	}
+	for range out {
+	}
+}
@@ -0,0 +1,264 @@
+package bblfsh
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+)
+
+// CacheKey identifies a cached parse response.
+type CacheKey struct {
+	// Hash is a SHA-256 hash of the file content.
+	Hash [sha256.Size]byte
+	// Language of the file, as set in the request.
+	Language string
+	// Mode is the UAST transformation mode.
+	Mode Mode
+	// Filename of the file, as set in the request.
+	Filename string
+	// Version is the version of the driver, or the server version if it's unknown.
+	Version string
+}
+
+// String returns a hex-encoded digest of the key. It can be used as a file name.
+func (k CacheKey) String() string {
+	h := sha256.New()
+	h.Write(k.Hash[:])
+	for _, s := range []string{k.Language, k.Filename, k.Version} {
+		var buf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(buf[:], uint64(len(s)))
+		h.Write(buf[:n])
+		h.Write([]byte(s))
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], int64(k.Mode))
+	h.Write(buf[:n])
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Cache is a storage for parse responses.
+//
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	// Get returns a cached response for the key, if any.
+	Get(key CacheKey) (*protocol2.ParseResponse, bool)
+	// Put stores a response in the cache. Errors are ignored by the client.
+	Put(key CacheKey, resp *protocol2.ParseResponse) error
+}
+
+// CacheStats contains statistics of the parse cache.
+type CacheStats struct {
+	// Hits is the number of requests served from the cache.
+	Hits uint64
+	// Misses is the number of requests sent to the server because the response was not cached.
+	Misses uint64
+}
+
+// parseCache tracks cache statistics.
+type parseCache struct {
+	// accessed atomically; keep first for alignment
+	hits   uint64
+	misses uint64
+
+	cache Cache
+}
+
+// key computes a cache key for the request.
+func (pc *parseCache) key(ctx context.Context, c *Client, req *protocol2.ParseRequest) (CacheKey, error) {
+	info, err := c.serverInfo(ctx, req.Language)
+	if err != nil {
+		return CacheKey{}, err
+	}
+	return CacheKey{
+		Hash:     sha256.Sum256([]byte(req.Content)),
+		Language: req.Language,
+		Mode:     req.Mode,
+		Filename: req.Filename,
+		Version:  info.driverVersion(req.Language),
+	}, nil
+}
+
+func (pc *parseCache) get(key CacheKey) (*protocol2.ParseResponse, bool) {
+	resp, ok := pc.cache.Get(key)
+	if ok {
+		atomic.AddUint64(&pc.hits, 1)
+	} else {
+		atomic.AddUint64(&pc.misses, 1)
+	}
+	return resp, ok
+}
+
+func (pc *parseCache) put(key CacheKey, resp *protocol2.ParseResponse) {
+	_ = pc.cache.Put(key, resp)
+}
+
+func (pc *parseCache) stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&pc.hits),
+		Misses: atomic.LoadUint64(&pc.misses),
+	}
+}
+
+// responseSize estimates the memory used by the response.
+func responseSize(resp *protocol2.ParseResponse) int64 {
+	n := len(resp.Uast) + len(resp.Language)
+	for _, e := range resp.Errors {
+		n += len(e.Text)
+	}
+	return int64(n)
+}
+
+// MemoryCache is an in-memory LRU cache of parse responses with a size budget.
+type MemoryCache struct {
+	mu    sync.Mutex
+	max   int64
+	size  int64
+	lru   *list.List
+	items map[CacheKey]*list.Element
+}
+
+type memoryEntry struct {
+	key  CacheKey
+	resp *protocol2.ParseResponse
+	size int64
+}
+
+var _ Cache = (*MemoryCache)(nil)
+
+// NewMemoryCache creates an in-memory LRU cache that holds at most maxBytes of responses.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		max:   maxBytes,
+		lru:   list.New(),
+		items: make(map[CacheKey]*list.Element),
+	}
+}
+
+// Get implements Cache.
+func (c *MemoryCache) Get(key CacheKey) (*protocol2.ParseResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	// shallow copy, so the caller cannot alter the cached response fields
+	resp := *el.Value.(*memoryEntry).resp
+	return &resp, true
+}
+
+// Put implements Cache.
+func (c *MemoryCache) Put(key CacheKey, resp *protocol2.ParseResponse) error {
+	sz := responseSize(resp)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	if sz > c.max {
+		return nil
+	}
+	cp := *resp
+	c.items[key] = c.lru.PushFront(&memoryEntry{key: key, resp: &cp, size: sz})
+	c.size += sz
+	for c.size > c.max {
+		c.removeElement(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) removeElement(el *list.Element) {
+	e := c.lru.Remove(el).(*memoryEntry)
+	delete(c.items, e.key)
+	c.size -= e.size
+}
+
+// Len returns the number of responses in the cache.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the size of responses stored in the cache.
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// DiskCache stores parse responses in a directory on disk.
+//
+// Responses are stored in the protobuf format, with UAST nodes serialized with nodesproto.
+type DiskCache struct {
+	dir string
+}
+
+var _ Cache = (*DiskCache)(nil)
+
+// NewDiskCache creates a cache that stores responses in a given directory.
+// The directory is created if it doesn't exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(key CacheKey) (string, string) {
+	h := key.String()
+	sub := filepath.Join(c.dir, h[:2])
+	return sub, filepath.Join(sub, h)
+}
+
+// Get implements Cache.
+func (c *DiskCache) Get(key CacheKey) (*protocol2.ParseResponse, bool) {
+	_, path := c.path(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var resp protocol2.ParseResponse
+	if err = resp.Unmarshal(data); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// Put implements Cache.
+func (c *DiskCache) Put(key CacheKey, resp *protocol2.ParseResponse) error {
+	data, err := resp.Marshal()
+	if err != nil {
+		return err
+	}
+	sub, path := c.path(key)
+	if err = os.MkdirAll(sub, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first, so concurrent readers never see a partial file
+	f, err := ioutil.TempFile(sub, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package bblfsh
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func testCacheKey(content string) CacheKey {
+	return CacheKey{
+		Hash:     sha256.Sum256([]byte(content)),
+		Language: "python",
+		Mode:     Semantic,
+		Filename: "file.py",
+		Version:  "v1.0.0",
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache(25)
+
+	resp := func(n int) *protocol2.ParseResponse {
+		return &protocol2.ParseResponse{Uast: make([]byte, n)}
+	}
+	require.NoError(t, c.Put(testCacheKey("a"), resp(10)))
+	require.NoError(t, c.Put(testCacheKey("b"), resp(10)))
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, int64(20), c.Size())
+
+	// touch "a", so "b" is evicted first
+	_, ok := c.Get(testCacheKey("a"))
+	require.True(t, ok)
+
+	require.NoError(t, c.Put(testCacheKey("c"), resp(10)))
+	require.Equal(t, 2, c.Len())
+	_, ok = c.Get(testCacheKey("b"))
+	require.False(t, ok)
+	_, ok = c.Get(testCacheKey("a"))
+	require.True(t, ok)
+	_, ok = c.Get(testCacheKey("c"))
+	require.True(t, ok)
+
+	// too large to be cached
+	require.NoError(t, c.Put(testCacheKey("d"), resp(100)))
+	_, ok = c.Get(testCacheKey("d"))
+	require.False(t, ok)
+	require.Equal(t, 2, c.Len())
+
+	// any field of the key matters
+	key := testCacheKey("a")
+	key.Version = "v2.0.0"
+	_, ok = c.Get(key)
+	require.False(t, ok)
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bblfsh-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, err := NewDiskCache(dir)
+	require.NoError(t, err)
+
+	_, ok := c.Get(testCacheKey("a"))
+	require.False(t, ok)
+
+	exp := &protocol2.ParseResponse{
+		Uast:     mockUAST,
+		Language: "python",
+		Errors:   []*protocol2.ParseError{{Text: "unexpected token"}},
+	}
+	require.NoError(t, c.Put(testCacheKey("a"), exp))
+
+	got, ok := c.Get(testCacheKey("a"))
+	require.True(t, ok)
+	require.Equal(t, exp.Uast, got.Uast)
+	require.Equal(t, exp.Language, got.Language)
+	require.Equal(t, exp.Errors[0].Text, got.Errors[0].Text)
+
+	_, err = got.Nodes()
+	require.True(t, ErrSyntax.Is(err))
+}
+
+func TestClientCache(t *testing.T) {
+	var calls int32
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			atomic.AddInt32(&calls, 1)
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+		languages: []*protocol2.Manifest{
+			{Language: "python", Version: &protocol2.Version{Version: "v1.2.3"}},
+		},
+	})
+	defer stop()
+
+	cache := NewMemoryCache(1 << 20)
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithCache(cache))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.NoError(t, err)
+	require.Equal(t, CacheStats{Misses: 1}, cli.CacheStats())
+
+	ast, lang, err := cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.NoError(t, err)
+	require.NotNil(t, ast)
+	require.Equal(t, "python", lang)
+	require.Equal(t, CacheStats{Hits: 1, Misses: 1}, cli.CacheStats())
+
+	resp, err := cli.NewParseRequest().Language("python").Content("import foo").Do()
+	require.NoError(t, err)
+	require.Equal(t, mockUAST, resp.Uast)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 1}, cli.CacheStats())
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// different mode
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").Mode(Native).Do()
+	require.NoError(t, err)
+	require.Equal(t, CacheStats{Hits: 2, Misses: 2}, cli.CacheStats())
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the driver version is a part of the key
+	key := testCacheKey("import foo")
+	key.Filename = ""
+	key.Mode = 0
+	key.Version = "v1.2.3"
+	_, ok := cache.Get(key)
+	require.True(t, ok)
+}
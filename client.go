@@ -3,9 +3,8 @@ package bblfsh
 import (
 	"context"
 	"errors"
-	"fmt"
 	"io"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/bblfsh/sdk/v3/driver"
@@ -15,7 +14,10 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -34,18 +36,167 @@ const (
 
 type ConnFunc func(ctx context.Context, language string) (*grpc.ClientConn, error)
 
+// ReplicaConnFunc returns connections to all the replicas serving a given language.
+type ReplicaConnFunc func(ctx context.Context, language string) ([]*grpc.ClientConn, error)
+
 // Client holds the public client API to interact with the bblfsh daemon.
 type Client struct {
 	closer  io.Closer
 	driver2 protocol2.DriverClient
 	driver  driver.Driver
+	opts    clientOptions
+	cache   *parseCache
+	limits  *clientLimits
+	// callOpts are added to each parse RPC
+	callOpts []grpc.CallOption
+
+	info *languageInfo
 }
 
 // NewClientContext returns a new bblfsh client given a bblfshd endpoint.
+// See ParseEndpoint for the supported endpoint formats.
+//
+// Use NewClientWithOptionsContext to configure the client with ClientOption.
 func NewClientContext(ctx context.Context, endpoint string, options ...grpc.DialOption) (*Client, error) {
+	return NewClientWithOptionsContext(ctx, endpoint, WithDialOptions(options...))
+}
+
+// NewClientWithOptionsContext is the same as NewClientContext, but accepts client options.
+// gRPC dial options can be set with WithDialOptions.
+func NewClientWithOptionsContext(ctx context.Context, endpoint string, options ...ClientOption) (*Client, error) {
+	conf, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientWithEndpointsContext(ctx, conf, options...)
+}
+
+// NewClientWithEndpointsContext returns a new bblfsh client given an endpoint configuration.
+// gRPC dial options can be set with WithDialOptions.
+func NewClientWithEndpointsContext(ctx context.Context, conf *EndpointConfig, copts ...ClientOption) (*Client, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	cconf := newClientOptions(copts)
+	tconf, options := cconf.tls, cconf.dialOpts
+
+	// prepare dial options in advance to report configuration errors early
+	dialOpts := make(map[*Endpoint][]grpc.DialOption)
+	endpoints := []*Endpoint{conf.Default, conf.Template}
+	for _, e := range conf.Languages {
+		endpoints = append(endpoints, e)
+	}
+	for _, e := range endpoints {
+		if e == nil {
+			continue
+		}
+		opts, err := dialOptions(tconf, e, options)
+		if err != nil {
+			return nil, err
+		}
+		dialOpts[e] = opts
+	}
+
+	if conf.single() {
+		opts := append([]grpc.DialOption{grpc.WithBlock()}, dialOpts[conf.Default]...)
+		conn, err := grpc.DialContext(ctx, conf.Default.Address, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return NewClientWithConnectionContext(ctx, conn, copts...)
+	}
+	// version and supported languages requests are sent to the mapped endpoints and the default
+	// one, in addition to the endpoints that were already used for parsing
+	known := make([]string, 0, len(conf.Languages)+1)
+	for lang := range conf.Languages {
+		known = append(known, lang)
+	}
+	sort.Strings(known)
+	if conf.Default != nil && conf.Template == nil {
+		known = append(known, "")
+	}
+	copts = append(copts, WithKnownLanguages(known...))
+	return NewClientWithReplicasContext(func(ctx context.Context, lang string) ([]*grpc.ClientConn, error) {
+		e, addrs, ok := conf.resolve(lang)
+		if !ok {
+			return nil, &driver.ErrMissingDriver{Language: lang}
+		}
+		return dialReplicas(ctx, addrs, dialOpts[e], cconf.logger)
+	}, copts...)
+}
+
+// dialReplicas dials all the addresses without blocking and waits until any of the replicas
+// is ready. Replicas that are not ready yet are kept, since gRPC keeps reconnecting them
+// in the background; the ones that keep failing are ejected by the balancer.
+//
+// An error is returned only if none of the replicas becomes ready before the context is done.
+func dialReplicas(ctx context.Context, addrs []string, opts []grpc.DialOption, log *clientLogger) ([]*grpc.ClientConn, error) {
+	var (
+		conns   []*grpc.ClientConn
+		lastErr error
+	)
+	for _, addr := range addrs {
+		conn, err := grpc.DialContext(ctx, addr, opts...)
+		if err != nil {
+			log.log(LevelWarn, "cannot dial replica", Field{"endpoint", addr}, Field{"error", err})
+			lastErr = err
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil, lastErr
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ready := make(chan struct{}, len(conns))
+	for _, conn := range conns {
+		go func(conn *grpc.ClientConn) {
+			for {
+				state := conn.GetState()
+				if state == connectivity.Ready {
+					ready <- struct{}{}
+					return
+				}
+				if !conn.WaitForStateChange(wctx, state) {
+					return
+				}
+			}
+		}(conn)
+	}
+	select {
+	case <-ready:
+		return conns, nil
+	case <-ctx.Done():
+	}
+	for _, conn := range conns {
+		log.log(LevelWarn, "cannot dial replica", Field{"endpoint", conn.Target()},
+			Field{"state", conn.GetState()}, Field{"error", ctx.Err()})
+		_ = conn.Close()
+	}
+	return nil, ctx.Err()
+}
+
+// dialOptions returns gRPC dial options for an endpoint.
+//
+// The endpoint TLS configuration overrides the client one. User-defined options are applied
+// after the default ones, and the endpoint options are applied last.
+// The options do not block the dial; see dialReplicas.
+func dialOptions(tconf *TLSConfig, e *Endpoint, options []grpc.DialOption) ([]grpc.DialOption, error) {
+	if e.TLS != nil {
+		tconf = e.TLS
+	}
+	creds := grpc.WithInsecure()
+	if tconf != nil {
+		tc, err := tconf.Config()
+		if err != nil {
+			return nil, err
+		}
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(tc))
+	}
 	opts := []grpc.DialOption{
-		grpc.WithBlock(),
-		grpc.WithInsecure(),
+		creds,
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:                keepalivePingInterval,
 			PermitWithoutStream: keepalivePingWithoutStream,
@@ -55,64 +206,55 @@ func NewClientContext(ctx context.Context, endpoint string, options ...grpc.Dial
 	// user-defined options should go last
 	// this allows to override any default option
 	opts = append(opts, options...)
+	opts = append(opts, e.DialOptions...)
+	return opts, nil
+}
 
-	switch {
-	case strings.Contains(endpoint, ","):
-		endpoints, err := parseEndpoints(endpoint)
-		if err != nil {
-			return nil, err
-		}
-		return NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
-			e, ok := endpoints[lang]
-			if !ok {
-				return nil, &driver.ErrMissingDriver{Language: lang}
-			}
-			conn, err := grpc.DialContext(ctx, e, opts...)
-			if err != nil {
-				return nil, err
-			}
-
-			return conn, nil
-		})
-	case strings.Contains(endpoint, "%s"):
-		return NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
-			conn, err := grpc.DialContext(ctx, fmt.Sprintf(endpoint, lang), opts...)
-			if err != nil {
-				return nil, err
-			}
+// NewClientWithConnectionsContext returns a new bblfsh client that dials a separate connection
+// for each language using the given function.
+func NewClientWithConnectionsContext(getConn ConnFunc, options ...ClientOption) (*Client, error) {
+	return NewClientWithReplicasContext(singleReplica(getConn), options...)
+}
 
-			return conn, nil
-		})
-	default:
-		conn, err := grpc.DialContext(ctx, endpoint, opts...)
+// singleReplica converts a ConnFunc to a ReplicaConnFunc that returns a single replica.
+func singleReplica(getConn ConnFunc) ReplicaConnFunc {
+	return func(ctx context.Context, lang string) ([]*grpc.ClientConn, error) {
+		conn, err := getConn(ctx, lang)
 		if err != nil {
 			return nil, err
 		}
-		return NewClientWithConnectionContext(ctx, conn)
+		return []*grpc.ClientConn{conn}, nil
 	}
 }
 
-func NewClientWithConnectionsContext(getConn ConnFunc) (*Client, error) {
-	dc := newMultipleDriverClient(getConn)
+// NewClientWithReplicasContext returns a new bblfsh client that dials a separate set of replicas
+// for each language using the given function. Requests are balanced between the replicas,
+// see WithLoadBalancing.
+func NewClientWithReplicasContext(getConns ReplicaConnFunc, options ...ClientOption) (*Client, error) {
+	opts := newClientOptions(options)
+	// requests are routed by language
+	opts.detect = true
+	dc := newMultipleDriverClient(getConns, opts)
+	host := withHostCallOptions(&multipleDriverHostClient{dc: dc, languages: opts.languages}, opts.callOptions())
 
-	return &Client{
-		closer:  dc,
-		driver2: dc,
-		driver:  protocol2.DriverFromClient(dc, &multipleDriverHostClient{}),
-	}, nil
+	return initClient(dc, dc, protocol2.DriverFromClient(dc, host), opts), nil
 }
 
-func parseEndpoints(endpoints string) (map[string]string, error) {
-	result := make(map[string]string)
-	pairs := strings.Split(endpoints, ",")
-	for _, p := range pairs {
-		vals := strings.Split(p, "=")
-		if len(vals) != 2 {
-			return nil, fmt.Errorf("formatting is broken in section: %q", p)
-		}
-		result[vals[0]] = vals[1]
+// initClient creates a client and initializes its internal state according to the options.
+func initClient(closer io.Closer, driver2 protocol2.DriverClient, drv driver.Driver, opts clientOptions) *Client {
+	c := &Client{
+		closer:   closer,
+		driver2:  driver2,
+		driver:   drv,
+		opts:     opts,
+		limits:   newClientLimits(opts.rateLimits),
+		callOpts: opts.callOptions(),
+	}
+	c.info = c.newLanguageInfo()
+	if opts.cache != nil {
+		c.cache = &parseCache{cache: opts.cache}
 	}
-	return result, nil
+	return c
 }
 
 // NewClient is the same as NewClientContext, but assumes a default timeout for the connection.
@@ -126,11 +268,11 @@ func NewClient(endpoint string, options ...grpc.DialOption) (*Client, error) {
 }
 
 // NewClientWithConnection returns a new bblfsh client given a grpc connection.
-func NewClientWithConnection(conn *grpc.ClientConn) (*Client, error) {
+func NewClientWithConnection(conn *grpc.ClientConn, options ...ClientOption) (*Client, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), defaultConnTimeout)
 	defer cancel()
 
-	return NewClientWithConnectionContext(ctx, conn)
+	return NewClientWithConnectionContext(ctx, conn, options...)
 }
 
 func isServiceNotSupported(err error) bool {
@@ -141,40 +283,41 @@ func isServiceNotSupported(err error) bool {
 }
 
 // NewClientWithConnectionContext returns a new bblfsh client given a grpc connection.
-func NewClientWithConnectionContext(ctx context.Context, conn *grpc.ClientConn) (*Client, error) {
-	host := protocol2.NewDriverHostClient(conn)
+func NewClientWithConnectionContext(ctx context.Context, conn *grpc.ClientConn, options ...ClientOption) (*Client, error) {
+	opts := newClientOptions(options)
+	callOpts := opts.callOptions()
+	host := withHostCallOptions(protocol2.NewDriverHostClient(conn), callOpts)
 	_, err := host.ServerVersion(ctx, &protocol2.VersionRequest{})
 	if err == nil {
 		// supports v2
-		return &Client{
-			closer:  conn,
-			driver2: protocol2.NewDriverClient(conn),
-			driver:  protocol2.AsDriver(conn),
-		}, nil
+		drv := protocol2.DriverFromClient(protocol2.NewDriverClient(conn), host)
+		return initClient(conn, protocol2.NewDriverClient(conn), drv, opts), nil
 	} else if !isServiceNotSupported(err) {
+		opts.logger.log(LevelError, "cannot get server version",
+			Field{"endpoint", conn.Target()}, Field{"error", err})
 		return nil, err
 	}
+	opts.logger.log(LevelWarn, "server does not support protocol v2, using v1 for version and supported languages",
+		Field{"endpoint", conn.Target()})
 	s1 := protocol1.NewProtocolServiceClient(conn)
-	return &Client{
-		closer:  conn,
-		driver2: protocol2.NewDriverClient(conn),
-		driver: &driverPartialV2{
-			// use only Parse from v2
-			Driver: protocol2.AsDriver(conn),
-			// use v1 for version and supported languages
-			service1: s1,
-		},
-	}, nil
+	return initClient(conn, protocol2.NewDriverClient(conn), &driverPartialV2{
+		// use only Parse from v2
+		Driver: protocol2.AsDriver(conn),
+		// use v1 for version and supported languages
+		service1: s1,
+		callOpts: callOpts,
+	}, opts), nil
 }
 
 type driverPartialV2 struct {
 	driver.Driver
 	service1 protocol1.ProtocolServiceClient
+	callOpts []grpc.CallOption
 }
 
 // Version implements a driver.Host using v1 protocol.
 func (d *driverPartialV2) Version(ctx context.Context) (driver.Version, error) {
-	resp, err := d.service1.Version(ctx, &protocol1.VersionRequest{})
+	resp, err := d.service1.Version(ctx, &protocol1.VersionRequest{}, d.callOpts...)
 	if err != nil {
 		return driver.Version{}, err
 	} else if resp.Status != protocol1.Ok {
@@ -188,7 +331,7 @@ func (d *driverPartialV2) Version(ctx context.Context) (driver.Version, error) {
 
 // Languages implements a driver.Host using v1 protocol.
 func (d *driverPartialV2) Languages(ctx context.Context) ([]manifest.Manifest, error) {
-	resp, err := d.service1.SupportedLanguages(ctx, &protocol1.SupportedLanguagesRequest{})
+	resp, err := d.service1.SupportedLanguages(ctx, &protocol1.SupportedLanguagesRequest{}, d.callOpts...)
 	if err != nil {
 		return nil, err
 	} else if resp.Status != protocol1.Ok {
@@ -211,6 +354,41 @@ func (d *driverPartialV2) Languages(ctx context.Context) ([]manifest.Manifest, e
 	return out, nil
 }
 
+// target returns the address of the server for single-endpoint clients.
+func (c *Client) target() string {
+	if conn, ok := c.closer.(*grpc.ClientConn); ok {
+		return conn.Target()
+	}
+	return ""
+}
+
+// startHostRequest starts a span for a version or supported languages request, if tracing
+// is enabled. The returned function finishes the span and logs the error, if any.
+func (c *Client) startHostRequest(ctx context.Context, name string) (context.Context, func(err error)) {
+	t, log := c.opts.tracer, c.opts.logger
+	target := c.target()
+	var span Span
+	if t != nil {
+		ctx, span = startSpan(ctx, t, name)
+		if target != "" {
+			span.SetAttribute(AttrEndpoint, target)
+		}
+	}
+	return ctx, func(err error) {
+		if span != nil {
+			endSpan(span, err)
+		}
+		if err != nil && log.enabled(LevelError) {
+			fields := []Field{{"request", name}}
+			if target != "" {
+				fields = append(fields, Field{"endpoint", target})
+			}
+			fields = append(fields, Field{"code", errorCode(err)}, Field{"error", err})
+			log.log(LevelError, "server request failed", fields...)
+		}
+	}
+}
+
 // NewParseRequest is a parsing request to get the UAST.
 func (c *Client) NewParseRequest() *ParseRequest {
 	return &ParseRequest{ctx: context.Background(), client: c}
@@ -226,6 +404,149 @@ func (c *Client) NewSupportedLanguagesRequest() *SupportedLanguagesRequest {
 	return &SupportedLanguagesRequest{ctx: context.Background(), client: c}
 }
 
+// parse sends a parse request to the server, retrying it according to the retry policy.
+//
+// If the client has a cache, it is consulted before sending the request.
+func (c *Client) parse(ctx context.Context, r *ParseRequest, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+	t, log := c.opts.tracer, c.opts.logger
+	if t == nil && log == nil {
+		return c.parseMetered(ctx, r, req)
+	}
+	r.peer = &peer.Peer{}
+	var span Span
+	if t != nil {
+		ctx, span = startSpan(ctx, t, SpanParse)
+		span.SetAttribute(AttrLanguage, req.Language)
+		span.SetAttribute(AttrFilename, req.Filename)
+		span.SetAttribute(AttrMode, Mode(req.Mode).String())
+		span.SetAttribute(AttrContentLength, len(req.Content))
+	}
+	resp, err := c.parseMetered(ctx, r, req)
+	lang := req.Language
+	if resp != nil && resp.Language != "" {
+		lang = resp.Language
+	}
+	if span != nil {
+		span.SetAttribute(AttrLanguage, lang)
+		setPeer(span, r.peer)
+		endSpan(span, err)
+	}
+	if err != nil {
+		fields := []Field{{"language", lang}, {"filename", req.Filename}}
+		if r.peer.Addr != nil {
+			fields = append(fields, Field{"endpoint", r.peer.Addr.String()})
+		}
+		fields = append(fields, Field{"code", errorCode(err)}, Field{"error", err})
+		log.log(LevelError, "parse request failed", fields...)
+	} else if len(resp.Errors) != 0 {
+		log.log(LevelDebug, "syntax errors in parsed file",
+			Field{"language", lang}, Field{"filename", req.Filename}, Field{"errors", len(resp.Errors)})
+	}
+	return resp, err
+}
+
+// parseMetered is the same as parse, but only records metrics, if enabled.
+func (c *Client) parseMetered(ctx context.Context, r *ParseRequest, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+	m := c.opts.metrics
+	if m == nil {
+		resp, _, err := c.parseCached(ctx, r, req)
+		return resp, err
+	}
+	start := time.Now()
+	resp, cached, err := c.parseCached(ctx, r, req)
+	pm := ParseMetric{
+		Language:    req.Language,
+		Duration:    time.Since(start),
+		ContentSize: len(req.Content),
+		Error:       errorClass(resp, err),
+		Cached:      cached,
+	}
+	if resp != nil {
+		if resp.Language != "" {
+			pm.Language = resp.Language
+		}
+		pm.UASTSize = len(resp.Uast)
+	}
+	m.ObserveParse(pm)
+	return resp, err
+}
+
+// parseCached sends a parse request or gets the response from the cache, if it's enabled.
+// It reports whether the response was cached.
+func (c *Client) parseCached(ctx context.Context, r *ParseRequest, req *protocol2.ParseRequest) (*protocol2.ParseResponse, bool, error) {
+	if c.cache == nil {
+		resp, err := c.send(ctx, r, req)
+		return resp, false, err
+	}
+	key, err := c.cache.key(ctx, c, req)
+	if err != nil {
+		// cannot determine the driver version; bypass the cache
+		resp, err := c.send(ctx, r, req)
+		return resp, false, err
+	}
+	resp, ok := c.cache.get(key)
+	if m := c.opts.metrics; m != nil {
+		m.ObserveCache(req.Language, ok)
+	}
+	if ok {
+		return resp, true, nil
+	}
+	resp, err = c.send(ctx, r, req)
+	if err == nil {
+		c.cache.put(key, resp)
+	}
+	return resp, false, err
+}
+
+// send sends a parse request to the server, retrying it according to the retry policy.
+func (c *Client) send(ctx context.Context, r *ParseRequest, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+	policy := c.opts.retry
+	if r.retry != nil {
+		policy = r.retry
+	}
+	timeout := c.opts.timeouts.parse(len(req.Content))
+	if r.timeout != 0 {
+		timeout = r.timeout
+	}
+	callOpts := c.callOpts
+	if r.peer != nil {
+		callOpts = append(callOpts[:len(callOpts):len(callOpts)], grpc.Peer(r.peer))
+	}
+	var (
+		resp    *protocol2.ParseResponse
+		err     error
+		attempt int
+	)
+	err = policy.do(ctx, func() error {
+		if attempt++; attempt > 1 {
+			c.opts.logger.log(LevelWarn, "retrying parse request",
+				Field{"language", req.Language}, Field{"filename", req.Filename},
+				Field{"attempt", attempt}, Field{"error", err})
+		}
+		release, lerr := c.limits.acquire(ctx, req.Language)
+		if lerr != nil {
+			resp, err = nil, lerr
+			r.timedOut = timeoutSource(ctx, ctx, err)
+			return err
+		}
+		defer release()
+		rctx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		resp, err = c.driver2.Parse(rctx, req, callOpts...)
+		r.timedOut = timeoutSource(ctx, rctx, err)
+		return err
+	})
+	return resp, err
+}
+
+// CacheStats returns statistics of the parse cache. It returns zero values if the cache is disabled.
+func (c *Client) CacheStats() CacheStats {
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.stats()
+}
+
 func (c *Client) GetConn() (*grpc.ClientConn, error) {
 	if conn, ok := c.closer.(*grpc.ClientConn); ok {
 		return conn, nil
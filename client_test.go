@@ -2,10 +2,13 @@ package bblfsh
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
 )
 
 func newClient(t testing.TB, endpoint string) *Client {
@@ -52,6 +55,31 @@ func TestMultiConnections(t *testing.T) {
 	testNativeParseRequestCustom(t, cli, "go", "package main")
 }
 
+func TestClient_DialOptions(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	var calls int32
+	count := grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		atomic.AddInt32(&calls, 1)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, endpoint := range []string{addr, "python=" + addr} {
+		atomic.StoreInt32(&calls, 0)
+		cli, err := NewClientWithOptionsContext(ctx, endpoint, WithDialOptions(count))
+		require.NoError(t, err)
+
+		_, err = cli.NewParseRequest().Language("python").Content("import foo").Do()
+		require.NoError(t, err)
+		require.NoError(t, cli.Close())
+		require.True(t, atomic.LoadInt32(&calls) > 0, endpoint)
+	}
+}
+
 func testParseRequest(t *testing.T, cli *Client) {
 	res, err := cli.NewParseRequest().Language("python").Content("import foo").Do()
 	require.NoError(t, err)
@@ -13,9 +13,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 
@@ -26,13 +28,24 @@ import (
 	"github.com/bblfsh/sdk/v3/uast/yaml"
 )
 
+// connTimeout is a timeout for connecting to the endpoint.
+const connTimeout = 5 * time.Second
+
 func main() {
 	var opts struct {
-		Host     string `short:"a" long:"host" description:"Babelfish endpoint address" default:"localhost:9432"`
-		Language string `short:"l" long:"language" description:"language to parse (default: auto)"`
-		Query    string `short:"q" long:"query" description:"XPath query applied to the resulting UAST"`
-		Mode     string `short:"m" long:"mode" description:"UAST transformation mode: semantic, annotated, native"`
-		Out      string `short:"o" long:"out" description:"Output format: yaml, json, bin" default:"yaml"`
+		Host      string `short:"a" long:"host" description:"Babelfish endpoint address" default:"localhost:9432"`
+		Endpoints string `short:"e" long:"endpoints" description:"Endpoint configuration file (YAML or JSON), overrides --host"`
+		Language  string `short:"l" long:"language" description:"language to parse (default: auto)"`
+		Query     string `short:"q" long:"query" description:"XPath query applied to the resulting UAST"`
+		Mode      string `short:"m" long:"mode" description:"UAST transformation mode: semantic, annotated, native"`
+		Out       string `short:"o" long:"out" description:"Output format: yaml, json, bin" default:"yaml"`
+
+		TLS           bool   `long:"tls" description:"Use TLS to connect to the endpoint"`
+		TLSCA         string `long:"tls-ca" description:"PEM-encoded CA bundle to verify the server certificate (implies --tls)"`
+		TLSCert       string `long:"tls-cert" description:"PEM-encoded client certificate for mutual TLS (implies --tls)"`
+		TLSKey        string `long:"tls-key" description:"PEM-encoded client key for mutual TLS (implies --tls)"`
+		TLSServerName string `long:"tls-server-name" description:"Override the server name used to verify the certificate (implies --tls)"`
+		TLSSkipVerify bool   `long:"tls-skip-verify" description:"Do not verify the server certificate, for development only (implies --tls)"`
 	}
 	args, err := flags.Parse(&opts)
 	if e, ok := err.(*flags.Error); ok && e.Type == flags.ErrHelp {
@@ -48,7 +61,31 @@ func main() {
 	}
 	filename := args[0]
 
-	client, err := bblfsh.NewClient(opts.Host)
+	var clientOpts []bblfsh.ClientOption
+	if opts.TLS || opts.TLSCA != "" || opts.TLSCert != "" || opts.TLSKey != "" ||
+		opts.TLSServerName != "" || opts.TLSSkipVerify {
+		clientOpts = append(clientOpts, bblfsh.WithTLS(bblfsh.TLSConfig{
+			CAFile:             opts.TLSCA,
+			CertFile:           opts.TLSCert,
+			KeyFile:            opts.TLSKey,
+			ServerName:         opts.TLSServerName,
+			InsecureSkipVerify: opts.TLSSkipVerify,
+		}))
+	}
+
+	var conf *bblfsh.EndpointConfig
+	if opts.Endpoints != "" {
+		conf, err = bblfsh.LoadEndpointConfig(opts.Endpoints)
+	} else {
+		conf, err = bblfsh.ParseEndpoint(opts.Host)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	client, err := bblfsh.NewClientWithEndpointsContext(ctx, conf, clientOpts...)
+	cancel()
 	if err != nil {
 		fatalf("couldn't create client: %v", err)
 	}
@@ -65,10 +102,12 @@ func main() {
 		req = req.Mode(m)
 	}
 	ast, _, err := req.UAST()
-	if bblfsh.ErrSyntax.Is(err) {
-		fatalfCode(2, "%v", err)
-	} else if bblfsh.ErrDriverFailure.Is(err) {
-		fatalfCode(3, "%v", err)
+	if perr, ok := err.(*bblfsh.ParseError); ok {
+		if bblfsh.ErrSyntax.Is(perr.Err) {
+			fatalfCode(2, "%v", err)
+		} else if bblfsh.ErrDriverFailure.Is(perr.Err) {
+			fatalfCode(3, "%v", err)
+		}
 	}
 	if err != nil {
 		fatalf("couldn't parse %s: %v", args[0], err)
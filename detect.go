@@ -0,0 +1,145 @@
+package bblfsh
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxDetectBytes is the maximal number of bytes of the content used by content heuristics.
+const maxDetectBytes = 16 * 1024
+
+// languageByExt maps file extensions to language names used by Babelfish drivers.
+var languageByExt = map[string]string{
+	".py":    "python",
+	".pyw":   "python",
+	".go":    "go",
+	".java":  "java",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".rb":    "ruby",
+	".php":   "php",
+	".cs":    "csharp",
+	".cpp":   "cpp",
+	".cc":    "cpp",
+	".cxx":   "cpp",
+	".hpp":   "cpp",
+	".hh":    "cpp",
+	".hxx":   "cpp",
+	".c":     "c",
+	".sh":    "bash",
+	".bash":  "bash",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".rs":    "rust",
+	".swift": "swift",
+	".lua":   "lua",
+	".pl":    "perl",
+	".pm":    "perl",
+}
+
+// languageByName maps well-known file names to languages.
+var languageByName = map[string]string{
+	"Rakefile":   "ruby",
+	"Gemfile":    "ruby",
+	"SConstruct": "python",
+}
+
+// languageByInterpreter maps interpreters in a shebang line to languages.
+var languageByInterpreter = map[string]string{
+	"python": "python",
+	"node":   "javascript",
+	"nodejs": "javascript",
+	"ruby":   "ruby",
+	"php":    "php",
+	"perl":   "perl",
+	"bash":   "bash",
+	"sh":     "bash",
+	"zsh":    "bash",
+	"lua":    "lua",
+}
+
+// contentHeuristics are checked in order, the first match wins.
+var contentHeuristics = []struct {
+	lang string
+	re   *regexp.Regexp
+}{
+	{"php", regexp.MustCompile(`<\?php`)},
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$[\s\S]*^func `)},
+	{"java", regexp.MustCompile(`(?m)^(package [\w.]+;|import java\.|(public )?(final )?class \w+)`)},
+	{"python", regexp.MustCompile(`(?m)^(def \w+\(.*\):|class \w+(\(.*\))?:|from [\w.]+ import |import [\w.]+\s*$|if __name__ == )`)},
+	{"javascript", regexp.MustCompile(`(?m)(\brequire\(['"]|^\s*module\.exports\b|^\s*console\.log\(|^function \w+\(.*\)\s*\{)`)},
+	{"ruby", regexp.MustCompile(`(?m)^(require ['"]|def \w+[?!]?(\(.*\))?\s*$|module \w+\s*$)`)},
+}
+
+// cppHeuristic distinguishes C++ headers from C headers.
+var cppHeuristic = regexp.MustCompile(`(?m)(^\s*(class|namespace|template)\b|\bstd::)`)
+
+// DetectLanguage guesses the language of a file by its name, a shebang line and the content.
+// Language names match the ones used by Babelfish drivers. It returns an empty string if the
+// language cannot be detected.
+//
+// Either the filename or the content may be empty.
+func DetectLanguage(filename, content string) string {
+	if len(content) > maxDetectBytes {
+		content = content[:maxDetectBytes]
+	}
+	if filename != "" {
+		base := filepath.Base(filename)
+		if lang, ok := languageByName[base]; ok {
+			return lang
+		}
+		ext := strings.ToLower(filepath.Ext(base))
+		if ext == ".h" {
+			if cppHeuristic.MatchString(content) {
+				return "cpp"
+			}
+			return "c"
+		}
+		if lang, ok := languageByExt[ext]; ok {
+			return lang
+		}
+	}
+	if lang := detectShebang(content); lang != "" {
+		return lang
+	}
+	for _, h := range contentHeuristics {
+		if h.re.MatchString(content) {
+			return h.lang
+		}
+	}
+	return ""
+}
+
+// detectShebang returns the language of the interpreter in a shebang line, if any.
+func detectShebang(content string) string {
+	if !strings.HasPrefix(content, "#!") {
+		return ""
+	}
+	line := content[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interp := filepath.Base(fields[0])
+	if interp == "env" {
+		// skip env flags, like in "#!/usr/bin/env -S python3 -u"
+		interp = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") && !strings.Contains(f, "=") {
+				interp = f
+				break
+			}
+		}
+	}
+	// strip the version, like in python3 or python2.7
+	interp = strings.TrimRight(interp, "0123456789.")
+	return languageByInterpreter[interp]
+}
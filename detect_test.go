@@ -0,0 +1,86 @@
+package bblfsh
+
+import (
+	"context"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+)
+
+var detectCases = []struct {
+	name     string
+	filename string
+	content  string
+	exp      string
+}{
+	{name: "ext", filename: "main.go", exp: "go"},
+	{name: "ext upper", filename: "src/Main.JAVA", exp: "java"},
+	{name: "file name", filename: "Rakefile", exp: "ruby"},
+	{name: "c header", filename: "foo.h", content: "int foo(void);\n", exp: "c"},
+	{name: "cpp header", filename: "foo.h", content: "namespace foo {\n}\n", exp: "cpp"},
+	{name: "shebang", filename: "run", content: "#!/bin/bash\necho 1\n", exp: "bash"},
+	{name: "shebang env", content: "#!/usr/bin/env python3\nprint(1)\n", exp: "python"},
+	{name: "shebang env flags", content: "#!/usr/bin/env -S node --harmony\n", exp: "javascript"},
+	{name: "php", content: "<html><?php echo 1; ?></html>", exp: "php"},
+	{name: "go", content: "package main\n\nfunc main() {}\n", exp: "go"},
+	{name: "java", content: "package foo.bar;\n\npublic class Foo {}\n", exp: "java"},
+	{name: "python", content: "import os\n\ndef foo(x):\n    return x\n", exp: "python"},
+	{name: "javascript", content: "const fs = require('fs');\n", exp: "javascript"},
+	{name: "ruby", content: "require 'json'\n\ndef foo?\nend\n", exp: "ruby"},
+	{name: "unknown", filename: "README", content: "Hello world\n", exp: ""},
+	{name: "empty", exp: ""},
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for _, c := range detectCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.exp, DetectLanguage(c.filename, c.content))
+		})
+	}
+}
+
+func TestParseRequest_DetectLanguage(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	var langs []string
+	cli, err := NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
+		langs = append(langs, lang)
+		return grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// multi-endpoint clients always detect the language
+	_, lang, err := cli.NewParseRequest().Filename("foo.py").Content("x = 1").UAST()
+	require.NoError(t, err)
+	require.Equal(t, "python", lang)
+	require.Equal(t, []string{"python"}, langs)
+
+	// explicit language is not overridden
+	_, lang, err = cli.NewParseRequest().Filename("foo.py").Language("go").Content("x = 1").UAST()
+	require.NoError(t, err)
+	require.Equal(t, "go", lang)
+}
+
+func TestParseRequest_DetectLanguageSingle(t *testing.T) {
+	var last string
+	cli, stop := newMockClient(t, &mockServer{parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+		last = req.Language
+		return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+	}})
+	defer stop()
+
+	_, err := cli.NewParseRequest().Filename("foo.rb").Content("puts 1").Do()
+	require.NoError(t, err)
+	require.Equal(t, "", last)
+
+	resp, err := cli.NewParseRequest().Filename("foo.rb").Content("puts 1").DetectLanguage().Do()
+	require.NoError(t, err)
+	require.Equal(t, "ruby", last)
+	require.Equal(t, "ruby", resp.Language)
+}
@@ -2,6 +2,11 @@ package bblfsh
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	protocol2 "github.com/bblfsh/sdk/v3/protocol"
 
@@ -16,30 +21,88 @@ import (
 	Examples of endpoint formats:
 	- localhost:9432 - casual example there's only one driver or bblfshd server
 	- python=localhost:9432,go=localhost:9432 - coma-separated mapping in format language=address
+	- javascript,typescript=localhost:9432,*=localhost:9433 - mapping with aliases and a fallback
 	- %s-driver.bblfsh.svc.example.com - DNS template based on the language
 */
 
 // multipleDriverClient is a DriverClient implementation, contains connection getter and a map[language]connection
+//
+// It is safe for concurrent use. Connections are dialed lazily, exactly once per language,
+// even if multiple goroutines request the same language at the same time.
 type multipleDriverClient struct {
-	getConn ConnFunc
+	getConns ReplicaConnFunc
+	opts     clientOptions
+	lb       LoadBalancing
+	// done is closed when the client is closed
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
 	// key is a language
 	drivers map[string]*connDriver
+	stats   ConnStats
+	// open is the number of established connections in drivers
+	open int
 }
 
+// connDriver is a lazily dialed set of connections to replicas serving a single language.
+//
+// The ready channel is closed when the dial completes. After that, either err is set,
+// or replicas are set. These fields must not be accessed before ready is closed.
 type connDriver struct {
-	conn   *grpc.ClientConn
-	driver protocol2.DriverClient
+	// next is a round-robin counter; accessed atomically
+	next uint32
+
+	lang     string
+	ready    chan struct{}
+	err      error
+	replicas []*replica
+
+	// protected by multipleDriverClient.mu
+	// inflight is the number of callers currently using the connection
+	inflight int
+	// evicted is set when the connection is removed from the client;
+	// it will be closed when the last caller releases it
+	evicted bool
+	// lastUsed is the last time the connection was acquired or released
+	lastUsed time.Time
+
+	// failures is the number of consecutive failed health checks; used only by the health checker
+	failures int
 }
 
-// multipleDriverHostClient is a DriverHostClient implementation, currently does almost nothing
-type multipleDriverHostClient struct{}
+// errNoEndpoints is returned by multipleDriverHostClient if there are no endpoints to send
+// the request to.
+var errNoEndpoints = status.Error(codes.Unimplemented, "no known endpoints")
+
+// multipleDriverHostClient is a DriverHostClient implementation that sends requests to all known endpoints
+// of multipleDriverClient and merges the responses.
+//
+// Known endpoints are the ones with established connections and the ones of the configured
+// languages. Other endpoints are never dialed by version or supported languages requests.
+type multipleDriverHostClient struct {
+	dc *multipleDriverClient
+	// languages that are always queried, dialing them if necessary; the ones that were
+	// already dialed for parsing are queried in addition to these
+	languages []string
+}
 
 // newMultipleDriverClient is a multipleDriverClient constructor
-func newMultipleDriverClient(getConn ConnFunc) *multipleDriverClient {
-	return &multipleDriverClient{
-		getConn: getConn,
-		drivers: make(map[string]*connDriver),
+func newMultipleDriverClient(getConns ReplicaConnFunc, opts clientOptions) *multipleDriverClient {
+	c := &multipleDriverClient{
+		getConns: getConns,
+		opts:     opts,
+		lb:       opts.balancing.withDefaults(),
+		done:     make(chan struct{}),
+		drivers:  make(map[string]*connDriver),
+	}
+	if opts.health != nil {
+		go c.healthLoop(opts.health.withDefaults())
+	}
+	if opts.limits.IdleTimeout > 0 {
+		go c.idleLoop(opts.limits.IdleTimeout)
 	}
+	return c
 }
 
 // Parse gets connection from a given map, or creates a new connection, then inits driver client and performs Parse
@@ -47,46 +110,508 @@ func (c *multipleDriverClient) Parse(
 	ctx context.Context,
 	in *protocol2.ParseRequest,
 	opts ...grpc.CallOption) (*protocol2.ParseResponse, error) {
-	lang := in.Language
+	connD, err := c.getDriver(ctx, in.Language)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(connD)
+	return connD.pick(c.lb).parse(ctx, c.lb, in, opts...)
+}
 
+// getDriver returns a connection for a given language, dialing it if necessary.
+// The caller must release the connection when done.
+//
+// Only the first caller for a language starts the dial, and all the callers wait for it
+// to complete or for their own context to be done. Failed dials are not cached, so the next
+// call will try to dial again.
+func (c *multipleDriverClient) getDriver(ctx context.Context, lang string) (*connDriver, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, grpc.ErrClientConnClosing
+	}
+	var evicted []*connDriver
 	connD, ok := c.drivers[lang]
 	if !ok {
-		gConn, err := c.getConn(ctx, lang)
-		if err != nil {
-			return nil, err
+		evicted = c.evictLRULocked()
+		connD = &connDriver{lang: lang, ready: make(chan struct{})}
+		c.drivers[lang] = connD
+	}
+	connD.inflight++
+	connD.lastUsed = time.Now()
+	c.mu.Unlock()
+
+	for _, e := range evicted {
+		e.close()
+	}
+
+	if !ok {
+		// other callers may wait for the same connection, so the dial must not depend
+		// on the context of this caller
+		go c.dial(lang, connD)
+	}
+
+	select {
+	case <-connD.ready:
+	case <-ctx.Done():
+		c.release(connD)
+		return nil, ctx.Err()
+	}
+	if connD.err != nil {
+		c.release(connD)
+		return nil, connD.err
+	}
+	return connD, nil
+}
+
+// release marks that the caller no longer uses the connection.
+// Evicted connections are closed when released by the last caller.
+func (c *multipleDriverClient) release(connD *connDriver) {
+	c.mu.Lock()
+	connD.inflight--
+	connD.lastUsed = time.Now()
+	closeConn := connD.evicted && connD.inflight == 0
+	c.mu.Unlock()
+	if closeConn {
+		connD.close()
+	}
+}
+
+// evict removes the connection from the client, so the next request for the language redials it.
+// The connection is closed when all the callers release it.
+//
+// The counter is incremented if the connection was removed by this call.
+func (c *multipleDriverClient) evict(connD *connDriver, counter *int64) {
+	c.mu.Lock()
+	closeConn := c.evictLocked(connD, counter)
+	c.mu.Unlock()
+	if closeConn {
+		connD.close()
+	}
+}
+
+// evictLocked is the same as evict, but requires the lock to be held.
+// It returns true if the caller must close the connection after releasing the lock.
+func (c *multipleDriverClient) evictLocked(connD *connDriver, counter *int64) bool {
+	if c.drivers[connD.lang] == connD {
+		delete(c.drivers, connD.lang)
+		*counter++
+		if connD.established() {
+			c.addOpenLocked(-1)
+		}
+		c.opts.logger.log(LevelInfo, "connection evicted",
+			Field{"language", connD.lang}, Field{"reason", c.evictReason(counter)})
+	}
+	closeConn := !connD.evicted && connD.inflight == 0
+	connD.evicted = true
+	return closeConn
+}
+
+// evictReason returns a reason of the eviction for logging, given the stats counter.
+func (c *multipleDriverClient) evictReason(counter *int64) string {
+	switch counter {
+	case &c.stats.EvictedIdle:
+		return "idle"
+	case &c.stats.EvictedLimit:
+		return "limit"
+	case &c.stats.EvictedUnhealthy:
+		return "unhealthy"
+	}
+	return "unknown"
+}
+
+// established checks if the connection was dialed successfully.
+func (connD *connDriver) established() bool {
+	select {
+	case <-connD.ready:
+		return connD.err == nil
+	default:
+		return false
+	}
+}
+
+// target returns the address of the primary replica.
+func (connD *connDriver) target() string {
+	return connD.replicas[0].conn.Target()
+}
+
+// close closes connections to all the replicas, if they were established.
+func (connD *connDriver) close() error {
+	if !connD.established() {
+		// failed, or still dialing; the dialer will close the connections itself
+		return nil
+	}
+	var lastErr error
+	for _, r := range connD.replicas {
+		if err := r.conn.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// dial establishes connections for connD and notifies all the goroutines waiting for it.
+//
+// The dial is limited by defaultConnTimeout and is cancelled if the client is closed.
+func (c *multipleDriverClient) dial(lang string, connD *connDriver) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultConnTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
 		}
-		connD = &connDriver{
-			conn:   gConn,
-			driver: protocol2.NewDriverClient(gConn),
+	}()
+
+	log := c.opts.logger
+	log.log(LevelDebug, "dialing", Field{"language", lang})
+	start := time.Now()
+	conns, err := c.getConns(ctx, lang)
+	if err == nil && len(conns) == 0 {
+		err = status.Errorf(codes.Unavailable, "no replicas for language %q", lang)
+	}
+	if err != nil {
+		log.log(LevelError, "dial failed", Field{"language", lang}, Field{"error", err})
+	} else if log.enabled(LevelInfo) {
+		targets := make([]string, 0, len(conns))
+		for _, conn := range conns {
+			targets = append(targets, conn.Target())
 		}
-		c.drivers[lang] = connD
+		log.log(LevelInfo, "dialed", Field{"language", lang},
+			Field{"endpoint", strings.Join(targets, replicaSeparator)}, Field{"elapsed", time.Since(start)})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer close(connD.ready)
+
+	if err == nil && (c.closed || connD.evicted) {
+		// client was closed while we were dialing
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		err = grpc.ErrClientConnClosing
+	} else if err != nil && c.closed {
+		err = grpc.ErrClientConnClosing
+	}
+	if err != nil {
+		connD.err = err
+		if c.drivers[lang] == connD {
+			delete(c.drivers, lang)
+		}
+		return
+	}
+	for _, conn := range conns {
+		connD.replicas = append(connD.replicas, newReplica(conn))
 	}
+	c.stats.Dialed++
+	c.addOpenLocked(1)
+}
 
-	return connD.driver.Parse(ctx, in, opts...)
+// addOpenLocked updates the number of open connections and reports it to metrics.
+func (c *multipleDriverClient) addOpenLocked(delta int) {
+	c.open += delta
+	if m := c.opts.metrics; m != nil {
+		m.SetOpenConns(c.open)
+	}
 }
 
+// Close closes all the connections that were established by the client.
+//
+// It is safe to call Close while other goroutines are parsing; those requests will either
+// complete or fail with a cancellation error. Any Parse call made after Close will fail.
 func (c *multipleDriverClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	drivers := c.drivers
+	c.drivers = make(map[string]*connDriver)
+	c.closed = true
+	for _, v := range drivers {
+		v.evicted = true
+	}
+	c.addOpenLocked(-c.open)
+	c.mu.Unlock()
+	close(c.done)
+
 	var lastErr error
-	for k, v := range c.drivers {
-		if err := v.conn.Close(); err != nil {
+	for _, v := range drivers {
+		if err := v.close(); err != nil {
 			lastErr = err
 		}
-		delete(c.drivers, k)
 	}
-	c.drivers = make(map[string]*connDriver)
 	return lastErr
 }
 
+// acquireEstablished returns all the connections that are already established, without dialing
+// new ones. The caller must release the returned connections.
+func (c *multipleDriverClient) acquireEstablished() []*connDriver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conns := make([]*connDriver, 0, len(c.drivers))
+	for _, connD := range c.drivers {
+		if !connD.established() || connD.evicted {
+			continue
+		}
+		connD.inflight++
+		connD.lastUsed = time.Now()
+		conns = append(conns, connD)
+	}
+	return conns
+}
+
+// hostEndpoint is a single endpoint of multipleDriverClient that may serve multiple languages.
+type hostEndpoint struct {
+	target    string
+	languages []string
+	host      protocol2.DriverHostClient
+}
+
+// serves checks if the endpoint is explicitly used for a given language.
+func (e *hostEndpoint) serves(lang string) bool {
+	for _, l := range e.languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// endpoints groups the languages with established connections by the endpoint address.
+// Known languages of the client, usually the mapped ones and the default endpoint, are dialed if necessary;
+// the ones that cannot be dialed are reported as endpoint errors.
+//
+// The caller must release the returned connections.
+func (hc *multipleDriverHostClient) endpoints(ctx context.Context) ([]*hostEndpoint, []*connDriver, []*EndpointError) {
+	used := hc.dc.acquireEstablished()
+	seen := make(map[string]struct{}, len(used))
+	for _, connD := range used {
+		seen[connD.lang] = struct{}{}
+	}
+	var dial []string
+	for _, lang := range hc.languages {
+		if _, ok := seen[lang]; !ok {
+			seen[lang] = struct{}{}
+			dial = append(dial, lang)
+		}
+	}
+
+	conns := make([]*connDriver, len(dial))
+	errs := make([]error, len(dial))
+	var wg sync.WaitGroup
+	for i, lang := range dial {
+		wg.Add(1)
+		go func(i int, lang string) {
+			defer wg.Done()
+			conns[i], errs[i] = hc.dc.getDriver(ctx, lang)
+		}(i, lang)
+	}
+	wg.Wait()
+
+	var failed []*EndpointError
+	for i, lang := range dial {
+		if errs[i] != nil {
+			failed = append(failed, &EndpointError{Languages: []string{lang}, Err: errs[i]})
+			continue
+		}
+		used = append(used, conns[i])
+	}
+	sort.Slice(used, func(i, j int) bool {
+		return used[i].lang < used[j].lang
+	})
+
+	var (
+		out    []*hostEndpoint
+		byAddr = make(map[string]*hostEndpoint)
+	)
+	for _, connD := range used {
+		target := connD.target()
+		e, ok := byAddr[target]
+		if !ok {
+			e = &hostEndpoint{target: target, host: protocol2.NewDriverHostClient(connD.pick(hc.dc.lb).conn)}
+			byAddr[target] = e
+			out = append(out, e)
+		}
+		e.languages = append(e.languages, connD.lang)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].target < out[j].target
+	})
+	return out, used, failed
+}
+
+// fanOut calls fnc for each known endpoint concurrently. It returns the endpoints that succeeded.
+//
+// Failed endpoints are reported to the collector in the context, if any. An error is returned
+// only if all the endpoints failed.
+func (hc *multipleDriverHostClient) fanOut(ctx context.Context, fnc func(e *hostEndpoint) error) ([]*hostEndpoint, error) {
+	endpoints, used, failed := hc.endpoints(ctx)
+	defer func() {
+		for _, connD := range used {
+			hc.dc.release(connD)
+		}
+	}()
+	if len(endpoints) == 0 && len(failed) == 0 {
+		return nil, errNoEndpoints
+	}
+
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, e := range endpoints {
+		wg.Add(1)
+		go func(i int, e *hostEndpoint) {
+			defer wg.Done()
+			errs[i] = fnc(e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	var ok []*hostEndpoint
+	for i, e := range endpoints {
+		if errs[i] != nil {
+			failed = append(failed, &EndpointError{Endpoint: e.target, Languages: e.languages, Err: errs[i]})
+			continue
+		}
+		ok = append(ok, e)
+	}
+	reportEndpointErrors(ctx, failed)
+	if len(ok) == 0 {
+		if len(failed) == 1 {
+			return nil, failed[0].Err
+		}
+		return nil, status.Errorf(codes.Unavailable, "all %d endpoints failed, first error: %v", len(failed), failed[0])
+	}
+	return ok, nil
+}
+
+// ServerVersion requests the version from all known endpoints.
+//
+// The version of the default endpoint is preferred, if it's known. Otherwise, the version of
+// the first endpoint (ordered by address) is returned.
 func (hc *multipleDriverHostClient) ServerVersion(
 	ctx context.Context,
 	in *protocol2.VersionRequest,
 	opts ...grpc.CallOption) (*protocol2.VersionResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "ServerVersion is not implemented")
+	var mu sync.Mutex
+	resps := make(map[*hostEndpoint]*protocol2.VersionResponse)
+	ok, err := hc.fanOut(ctx, func(e *hostEndpoint) error {
+		resp, err := e.host.ServerVersion(ctx, in, opts...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		resps[e] = resp
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ok {
+		// default endpoint serves requests with no language
+		if e.serves("") {
+			return resps[e], nil
+		}
+	}
+	return resps[ok[0]], nil
 }
 
+// SupportedLanguages requests the list of languages from all known endpoints and merges them.
+//
+// If multiple endpoints support the same language, the manifest from the endpoint that is
+// mapped to this language is preferred.
 func (hc *multipleDriverHostClient) SupportedLanguages(
 	ctx context.Context,
 	in *protocol2.SupportedLanguagesRequest,
 	opts ...grpc.CallOption) (*protocol2.SupportedLanguagesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "SupportedLanguages is not implemented")
+	var mu sync.Mutex
+	resps := make(map[*hostEndpoint]*protocol2.SupportedLanguagesResponse)
+	ok, err := hc.fanOut(ctx, func(e *hostEndpoint) error {
+		resp, err := e.host.SupportedLanguages(ctx, in, opts...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		resps[e] = resp
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	type manifestFrom struct {
+		m      *protocol2.Manifest
+		mapped bool
+	}
+	byLang := make(map[string]manifestFrom)
+	var langs []string
+	for _, e := range ok {
+		for _, m := range resps[e].Languages {
+			mapped := e.serves(m.Language)
+			prev, seen := byLang[m.Language]
+			if !seen {
+				langs = append(langs, m.Language)
+			} else if prev.mapped || !mapped {
+				continue
+			}
+			byLang[m.Language] = manifestFrom{m: m, mapped: mapped}
+		}
+	}
+	sort.Strings(langs)
+	out := &protocol2.SupportedLanguagesResponse{Languages: make([]*protocol2.Manifest, 0, len(langs))}
+	for _, lang := range langs {
+		out.Languages = append(out.Languages, byLang[lang].m)
+	}
+	return out, nil
+}
+
+// EndpointError is an error returned by one of the endpoints of a multi-endpoint client.
+type EndpointError struct {
+	// Endpoint is the address of the endpoint. It's empty if the connection cannot be established.
+	Endpoint string
+	// Languages that are served by the endpoint.
+	Languages []string
+	// Err is the error returned by the endpoint.
+	Err error
+}
+
+func (e *EndpointError) Error() string {
+	if e.Endpoint == "" {
+		return fmt.Sprintf("languages %q: %v", e.Languages, e.Err)
+	}
+	return fmt.Sprintf("endpoint %s (languages %q): %v", e.Endpoint, e.Languages, e.Err)
+}
+
+type endpointErrorsKey struct{}
+
+// endpointErrors collects errors of individual endpoints during a multi-endpoint request.
+type endpointErrors struct {
+	mu   sync.Mutex
+	errs []*EndpointError
+}
+
+// withEndpointErrors returns a context that collects errors of individual endpoints.
+func withEndpointErrors(ctx context.Context) (context.Context, *endpointErrors) {
+	errs := &endpointErrors{}
+	return context.WithValue(ctx, endpointErrorsKey{}, errs), errs
+}
+
+// reportEndpointErrors adds errors to the collector in the context, if any.
+func reportEndpointErrors(ctx context.Context, errs []*EndpointError) {
+	c, ok := ctx.Value(endpointErrorsKey{}).(*endpointErrors)
+	if !ok || len(errs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.errs = append(c.errs, errs...)
+	c.mu.Unlock()
+}
+
+func (c *endpointErrors) list() []*EndpointError {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*EndpointError(nil), c.errs...)
 }
@@ -0,0 +1,361 @@
+package bblfsh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bblfsh/sdk/v3/driver"
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/bblfsh/sdk/v3/uast/nodes"
+	"github.com/bblfsh/sdk/v3/uast/nodes/nodesproto"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mockUAST is a pre-encoded UAST returned by mockServer.
+//
+// It's encoded only once, since nodesproto is not safe for concurrent use.
+var mockUAST = func() []byte {
+	buf := new(bytes.Buffer)
+	err := nodesproto.WriteTo(buf, nodes.Object{
+		"@type": nodes.String("mock:File"),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}()
+
+// mockServer implements bblfsh protocol server for tests.
+type mockServer struct {
+	parse     func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error)
+	languages []*protocol2.Manifest
+	version   string
+	// hostErr is returned for version and supported languages requests, if set
+	hostErr error
+}
+
+func (s *mockServer) Parse(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+	if s.parse != nil {
+		return s.parse(ctx, req)
+	}
+	return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+}
+
+func (s *mockServer) ServerVersion(ctx context.Context, _ *protocol2.VersionRequest) (*protocol2.VersionResponse, error) {
+	if s.hostErr != nil {
+		return nil, s.hostErr
+	}
+	vers := s.version
+	if vers == "" {
+		vers = "mock"
+	}
+	return &protocol2.VersionResponse{Version: &protocol2.Version{Version: vers}}, nil
+}
+
+func (s *mockServer) SupportedLanguages(ctx context.Context, _ *protocol2.SupportedLanguagesRequest) (*protocol2.SupportedLanguagesResponse, error) {
+	if s.hostErr != nil {
+		return nil, s.hostErr
+	}
+	if s.languages != nil {
+		return &protocol2.SupportedLanguagesResponse{Languages: s.languages}, nil
+	}
+	return &protocol2.SupportedLanguagesResponse{
+		Languages: []*protocol2.Manifest{{Name: "Mock", Language: "mock"}},
+	}, nil
+}
+
+// newMockServer starts an in-process bblfsh server backed by s and returns its address
+// and a function to stop the server.
+func newMockServer(t testing.TB, s *mockServer, opts ...grpc.ServerOption) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(opts...)
+	protocol2.RegisterDriverServer(srv, s)
+	protocol2.RegisterDriverHostServer(srv, s)
+	go srv.Serve(lis)
+
+	return lis.Addr().String(), srv.Stop
+}
+
+// countingConnFunc returns a ConnFunc dialing addr and the map counting dials per language.
+func countingConnFunc(addr string) (ConnFunc, *sync.Map) {
+	var dials sync.Map
+	return func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
+		v, _ := dials.LoadOrStore(lang, new(int32))
+		atomic.AddInt32(v.(*int32), 1)
+		// make the dial slow enough for other goroutines to pile up
+		time.Sleep(10 * time.Millisecond)
+		return grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	}, &dials
+}
+
+func TestMultipleDriverClient_ConcurrentParse(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, dials := countingConnFunc(addr)
+
+	cli, err := NewClientWithConnectionsContext(getConn)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	langs := []string{"python", "go", "java"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		lang := langs[i%len(langs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			resp, err := cli.NewParseRequest().Context(ctx).
+				Language(lang).Content("foo").Do()
+			if err == nil && resp.Language != lang {
+				err = fmt.Errorf("expected %q, got %q", lang, resp.Language)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	for _, lang := range langs {
+		v, ok := dials.Load(lang)
+		require.True(t, ok, lang)
+		require.Equal(t, int32(1), atomic.LoadInt32(v.(*int32)), lang)
+	}
+}
+
+func TestMultipleDriverClient_DialErrorNotCached(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	var calls int32
+	cli, err := NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, &driver.ErrMissingDriver{Language: lang}
+		}
+		return grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.True(t, driver.IsMissingDriver(parseErrCause(t, err)))
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestMultipleDriverClient_DialCancelled(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	started := make(chan struct{})
+	block := make(chan struct{})
+	var calls int32
+	cli, err := NewClientWithConnectionsContext(func(ctx context.Context, lang string) (*grpc.ClientConn, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-block
+		return grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// the first caller starts the dial and gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cli.NewParseRequest().Context(ctx).Language("python").Content("foo").Do()
+		first <- err
+	}()
+	<-started
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cli.NewParseRequest().Language("python").Content("foo").Do()
+		second <- err
+	}()
+	cancel()
+	err = <-first
+	require.Error(t, err)
+	require.Contains(t, err.Error(), context.Canceled.Error())
+
+	// the dial continues for the callers that still wait for it
+	close(block)
+	require.NoError(t, <-second)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestMultipleDriverClient_CloseDuringParse(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, _ := countingConnFunc(addr)
+
+	cli, err := NewClientWithConnectionsContext(getConn)
+	require.NoError(t, err)
+
+	langs := []string{"python", "go", "java", "ruby"}
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		lang := langs[i%len(langs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// requests may either succeed or fail because of Close, but must not race or panic
+			_, _ = cli.NewParseRequest().Context(ctx).Language(lang).Content("foo").Do()
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, cli.Close())
+	wg.Wait()
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.Equal(t, grpc.ErrClientConnClosing, parseErrCause(t, err))
+
+	// closing twice is safe
+	require.NoError(t, cli.Close())
+}
+
+func TestMultipleDriverHostClient(t *testing.T) {
+	pyAddr, stop := newMockServer(t, &mockServer{
+		version: "python-driver",
+		languages: []*protocol2.Manifest{
+			{Language: "python", Version: &protocol2.Version{Version: "v2"}},
+		},
+	})
+	defer stop()
+	defaultAddr, stop2 := newMockServer(t, &mockServer{
+		version: "bblfshd",
+		languages: []*protocol2.Manifest{
+			{Language: "python", Version: &protocol2.Version{Version: "v1"}},
+			{Language: "go", Version: &protocol2.Version{Version: "v1"}},
+		},
+	})
+	defer stop2()
+	failAddr, stop3 := newMockServer(t, &mockServer{
+		hostErr: status.Error(codes.Internal, "broken"),
+	})
+	defer stop3()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	endpoint := "python,javascript=" + pyAddr + ",java=" + failAddr + "," + defaultAddr
+	cli, err := NewClientContext(ctx, endpoint)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// the mapped endpoints and the default one are queried before anything is parsed
+	vreq := cli.NewVersionRequest().Context(ctx)
+	vers, err := vreq.Do()
+	require.NoError(t, err)
+	require.Equal(t, "bblfshd", vers.Version)
+	require.Len(t, vreq.Failures(), 1)
+	require.Equal(t, failAddr, vreq.Failures()[0].Endpoint)
+	require.Equal(t, []string{"java"}, vreq.Failures()[0].Languages)
+	require.Equal(t, int64(4), cli.ConnStats().Dialed)
+
+	lreq := cli.NewSupportedLanguagesRequest().Context(ctx)
+	list, err := lreq.DoV2()
+	require.NoError(t, err)
+	require.Len(t, lreq.Failures(), 1)
+	require.Len(t, list, 2)
+	require.Equal(t, "go", list[0].Language)
+	require.Equal(t, "v1", list[0].Version)
+	// the manifest of the mapped endpoint is preferred
+	require.Equal(t, "python", list[1].Language)
+	require.Equal(t, "v2", list[1].Version)
+
+	// parsing doesn't change the set of queried endpoints
+	_, err = cli.NewParseRequest().Context(ctx).Language("python").Content("import foo").Do()
+	require.NoError(t, err)
+
+	lreq = cli.NewSupportedLanguagesRequest().Context(ctx)
+	list2, err := lreq.DoV2()
+	require.NoError(t, err)
+	require.Len(t, lreq.Failures(), 1)
+	require.Equal(t, list, list2)
+
+	// a client with language mappings only
+	cli2, err := NewClientContext(ctx, "python="+pyAddr+",go="+defaultAddr)
+	require.NoError(t, err)
+	defer cli2.Close()
+
+	vreq = cli2.NewVersionRequest().Context(ctx)
+	vers, err = vreq.Do()
+	require.NoError(t, err)
+	require.Empty(t, vreq.Failures())
+	// there is no default endpoint, so the first one by address is used
+	if defaultAddr < pyAddr {
+		require.Equal(t, "bblfshd", vers.Version)
+	} else {
+		require.Equal(t, "python-driver", vers.Version)
+	}
+
+	list, err = cli2.NewSupportedLanguagesRequest().Context(ctx).DoV2()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "go", list[0].Language)
+	require.Equal(t, "python", list[1].Language)
+	require.Equal(t, "v2", list[1].Version)
+}
+
+func TestMultipleDriverHostClient_Errors(t *testing.T) {
+	failAddr, stop := newMockServer(t, &mockServer{
+		hostErr: status.Error(codes.Internal, "broken"),
+	})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// nothing is known about a template endpoint until it's used
+	_, port, err := net.SplitHostPort(failAddr)
+	require.NoError(t, err)
+	cli, err := NewClientContext(ctx, "127.0.0.%s:"+port)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewVersionRequest().Context(ctx).Do()
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = cli.NewParseRequest().Context(ctx).Language("1").Content("foo").Do()
+	require.NoError(t, err)
+
+	_, err = cli.NewVersionRequest().Context(ctx).Do()
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	// all endpoints fail
+	failAddr2, stop2 := newMockServer(t, &mockServer{
+		hostErr: status.Error(codes.Unavailable, "broken"),
+	})
+	defer stop2()
+
+	endpoint := "python=" + failAddr + ",go=" + failAddr2
+	cli2, err := NewClientContext(ctx, endpoint)
+	require.NoError(t, err)
+	defer cli2.Close()
+
+	// mapped endpoints are queried even if nothing is dialed yet
+	req := cli2.NewSupportedLanguagesRequest().Context(ctx)
+	_, err = req.DoV2()
+	require.Error(t, err)
+	require.Len(t, req.Failures(), 2)
+}
@@ -0,0 +1,282 @@
+package bblfsh
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/bblfsh/sdk/v3/uast"
+	"github.com/bblfsh/sdk/v3/uast/nodes"
+)
+
+// Encoding is a character encoding of a source file.
+type Encoding string
+
+const (
+	UTF8    Encoding = "utf-8"
+	UTF16LE Encoding = "utf-16le"
+	UTF16BE Encoding = "utf-16be"
+	// Latin1 is ISO-8859-1. It is assumed for files that are not valid UTF-8 and have no BOM.
+	Latin1 Encoding = "iso-8859-1"
+
+	// autoEncoding requests the encoding detection
+	autoEncoding Encoding = "auto"
+)
+
+var (
+	bomUTF8    = "\xef\xbb\xbf"
+	bomUTF16LE = "\xff\xfe"
+	bomUTF16BE = "\xfe\xff"
+)
+
+// maxDetectEncodingBytes is the maximal number of bytes used to detect UTF-16 without a BOM.
+const maxDetectEncodingBytes = 4 * 1024
+
+// DetectEncoding guesses the encoding of the content by the BOM and the byte patterns.
+// It only distinguishes UTF-8, UTF-16 and Latin-1.
+func DetectEncoding(content string) Encoding {
+	switch {
+	case strings.HasPrefix(content, bomUTF8):
+		return UTF8
+	case strings.HasPrefix(content, bomUTF16LE):
+		return UTF16LE
+	case strings.HasPrefix(content, bomUTF16BE):
+		return UTF16BE
+	}
+	if enc := detectUTF16(content); enc != "" {
+		return enc
+	}
+	if utf8.ValidString(content) {
+		return UTF8
+	}
+	return Latin1
+}
+
+// detectUTF16 checks if the content looks like a mostly ASCII text in UTF-16.
+func detectUTF16(content string) Encoding {
+	if len(content) > maxDetectEncodingBytes {
+		content = content[:maxDetectEncodingBytes]
+	}
+	pairs := len(content) / 2
+	if pairs == 0 || strings.IndexByte(content, 0) < 0 {
+		return ""
+	}
+	var even, odd int
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i] == 0 {
+			even++
+		}
+		if content[i+1] == 0 {
+			odd++
+		}
+	}
+	switch {
+	case odd >= pairs/2 && even <= pairs/10:
+		return UTF16LE
+	case even >= pairs/2 && odd <= pairs/10:
+		return UTF16BE
+	}
+	return ""
+}
+
+// transcode converts the content in a given encoding to UTF-8, stripping the BOM.
+// It returns the map from the offsets in the new content to the original offsets.
+// Invalid characters are replaced with utf8.RuneError.
+func transcode(content string, enc Encoding) (string, *offsetMap) {
+	m := &offsetMap{}
+	switch enc {
+	case UTF16LE, UTF16BE:
+		return transcodeUTF16(content, enc == UTF16BE, m), m
+	case Latin1:
+		var buf strings.Builder
+		buf.Grow(len(content))
+		for i := 0; i < len(content); i++ {
+			c := content[i]
+			m.add(uint32(buf.Len()), uint32(i), utf8.RuneLen(rune(c)), 1)
+			buf.WriteRune(rune(c))
+		}
+		return buf.String(), m
+	}
+	start := 0
+	if strings.HasPrefix(content, bomUTF8) {
+		start = len(bomUTF8)
+		m.add(0, uint32(start), 1, 1)
+		content = content[start:]
+	}
+	if !utf8.ValidString(content) {
+		content = toValidUTF8(content, start, m)
+	}
+	return content, m
+}
+
+// toValidUTF8 replaces each run of invalid UTF-8 bytes with the replacement character,
+// like strings.ToValidUTF8, which is not available in Go 1.12. The replaced runs are recorded
+// in the offset map; orig is the offset of s in the original content.
+func toValidUTF8(s string, orig int, m *offsetMap) string {
+	invalid := func(s string) bool {
+		r, n := utf8.DecodeRuneInString(s)
+		return r == utf8.RuneError && n == 1
+	}
+	var buf strings.Builder
+	buf.Grow(len(s))
+	for i := 0; i < len(s); {
+		if !invalid(s[i:]) {
+			_, n := utf8.DecodeRuneInString(s[i:])
+			buf.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		start := i
+		for i < len(s) && invalid(s[i:]) {
+			i++
+		}
+		m.add(uint32(buf.Len()), uint32(orig+start), utf8.RuneLen(utf8.RuneError), i-start)
+		buf.WriteRune(utf8.RuneError)
+		if i < len(s) {
+			// valid characters are copied as is
+			m.add(uint32(buf.Len()), uint32(orig+i), 1, 1)
+		}
+	}
+	return buf.String()
+}
+
+func transcodeUTF16(content string, be bool, m *offsetMap) string {
+	bom := bomUTF16LE
+	if be {
+		bom = bomUTF16BE
+	}
+	start := 0
+	if strings.HasPrefix(content, bom) {
+		start = len(bom)
+	}
+	units := make([]uint16, 0, (len(content)-start)/2)
+	for i := start; i+1 < len(content); i += 2 {
+		u := uint16(content[i]) | uint16(content[i+1])<<8
+		if be {
+			u = uint16(content[i])<<8 | uint16(content[i+1])
+		}
+		units = append(units, u)
+	}
+	var buf strings.Builder
+	buf.Grow(len(units))
+	orig := start
+	for i := 0; i < len(units); i++ {
+		r, width := rune(units[i]), 2
+		if utf16.IsSurrogate(r) {
+			r = utf8.RuneError
+			if i+1 < len(units) {
+				if p := utf16.DecodeRune(rune(units[i]), rune(units[i+1])); p != utf8.RuneError {
+					r, width = p, 4
+					i++
+				}
+			}
+		}
+		m.add(uint32(buf.Len()), uint32(orig), utf8.RuneLen(r), width)
+		buf.WriteRune(r)
+		orig += width
+	}
+	if orig < len(content) {
+		// odd number of bytes
+		m.add(uint32(buf.Len()), uint32(orig), utf8.RuneLen(utf8.RuneError), 1)
+		buf.WriteRune(utf8.RuneError)
+	}
+	return buf.String()
+}
+
+// offsetMap maps byte offsets in the transcoded content to offsets in the original content.
+type offsetMap struct {
+	// runs of characters with the same width in both encodings, sorted by offset
+	runs []offsetRun
+}
+
+type offsetRun struct {
+	// off and orig are offsets of the run start in the transcoded and the original content
+	off, orig uint32
+	// width and origWidth are the sizes of a single character in the run
+	width, origWidth uint32
+}
+
+// add records a character at given offsets. Characters must be added in order.
+func (m *offsetMap) add(off, orig uint32, width, origWidth int) {
+	if n := len(m.runs); n != 0 {
+		last := m.runs[n-1]
+		if last.width == uint32(width) && last.origWidth == uint32(origWidth) {
+			return
+		}
+	}
+	m.runs = append(m.runs, offsetRun{off: off, orig: orig, width: uint32(width), origWidth: uint32(origWidth)})
+}
+
+// identity checks if the offsets are the same in both contents.
+func (m *offsetMap) identity() bool {
+	for _, r := range m.runs {
+		if r.off != r.orig || r.width != r.origWidth {
+			return false
+		}
+	}
+	return true
+}
+
+// original returns the original offset for an offset in the transcoded content.
+// Offsets inside a character are mapped to the start of the character.
+func (m *offsetMap) original(off uint32) uint32 {
+	i := sort.Search(len(m.runs), func(i int) bool { return m.runs[i].off > off }) - 1
+	if i < 0 {
+		return off
+	}
+	r := m.runs[i]
+	return r.orig + (off-r.off)/r.width*r.origWidth
+}
+
+// position maps a position in the transcoded content to the original content.
+// Lines are the same in both contents; the column is recomputed from the offsets.
+func (m *offsetMap) position(p uast.Position) uast.Position {
+	if !p.HasOffset() {
+		return p
+	}
+	off := p.Offset
+	p.Offset = m.original(off)
+	if p.Col != 0 && p.Col-1 <= off {
+		lineStart := off - (p.Col - 1)
+		if lineStart != 0 {
+			lineStart = m.original(lineStart)
+		}
+		p.Col = p.Offset - lineStart + 1
+	}
+	return p
+}
+
+// positions maps all positions in the UAST to the original content. The tree is not modified.
+func (m *offsetMap) positions(n nodes.Node) nodes.Node {
+	out, _ := nodes.Apply(n, func(n nodes.Node) (nodes.Node, bool) {
+		obj, ok := n.(nodes.Object)
+		if !ok || uast.TypeOf(obj) != uast.TypePosition {
+			return n, false
+		}
+		p := uast.AsPosition(obj)
+		np := m.position(*p)
+		if np == *p {
+			return n, false
+		}
+		po := np.ToObject()
+		obj = obj.CloneObject()
+		for _, k := range []string{uast.KeyPosOff, uast.KeyPosCol} {
+			if _, ok := obj[k]; ok {
+				obj[k] = po[k]
+			}
+		}
+		return obj, true
+	})
+	return out
+}
+
+// syntaxErrors maps positions of syntax errors to the original content.
+func (m *offsetMap) syntaxErrors(errs SyntaxErrors) {
+	for i, e := range errs {
+		if e.Position != nil {
+			p := m.position(*e.Position)
+			errs[i].Position = &p
+		}
+	}
+}
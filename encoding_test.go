@@ -0,0 +1,137 @@
+package bblfsh
+
+import (
+	"testing"
+
+	"github.com/bblfsh/sdk/v3/uast"
+	"github.com/bblfsh/sdk/v3/uast/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+// utf16le encodes an ASCII string as UTF-16LE.
+func utf16le(s string) string {
+	out := make([]byte, 0, 2*len(s))
+	for i := 0; i < len(s); i++ {
+		out = append(out, s[i], 0)
+	}
+	return string(out)
+}
+
+func TestDetectEncoding(t *testing.T) {
+	cases := []struct {
+		content string
+		enc     Encoding
+	}{
+		{"", UTF8},
+		{"x = 1\n", UTF8},
+		{"x = 'é'\n", UTF8},
+		{bomUTF8 + "x = 1\n", UTF8},
+		{bomUTF16LE + "x\x00", UTF16LE},
+		{bomUTF16BE + "\x00x", UTF16BE},
+		{utf16le("x = 1\n"), UTF16LE},
+		{"\x00x\x00 \x00=\x00 \x001", UTF16BE},
+		{"x = '\xe9'\n", Latin1},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.enc, DetectEncoding(c.content), "%q", c.content)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		enc     Encoding
+		exp     string
+		// offsets maps offsets in the transcoded content to the original offsets
+		offsets map[uint32]uint32
+	}{
+		{
+			name: "utf8", content: "a\nb", enc: UTF8, exp: "a\nb",
+			offsets: map[uint32]uint32{0: 0, 2: 2, 3: 3},
+		},
+		{
+			name: "utf8 bom", content: bomUTF8 + "a\nb", enc: UTF8, exp: "a\nb",
+			offsets: map[uint32]uint32{0: 3, 2: 5, 3: 6},
+		},
+		{
+			name: "utf16le bom", content: bomUTF16LE + utf16le("a\nb"), enc: UTF16LE, exp: "a\nb",
+			offsets: map[uint32]uint32{0: 2, 2: 6, 3: 8},
+		},
+		{
+			name: "utf16be", content: "\x00a\x00\xe9\xd8\x3d\xde\x00\x00b", enc: UTF16BE, exp: "aé😀b",
+			offsets: map[uint32]uint32{0: 0, 1: 2, 2: 2, 3: 4, 7: 8, 8: 10},
+		},
+		{
+			name: "utf16 odd", content: "a\x00b", enc: UTF16LE, exp: "a�",
+			offsets: map[uint32]uint32{0: 0, 1: 2, 4: 3},
+		},
+		{
+			name: "utf8 invalid", content: "a\xff\xfeb\xffc", enc: UTF8, exp: "a\uFFFDb\uFFFDc",
+			offsets: map[uint32]uint32{0: 0, 1: 1, 3: 1, 4: 3, 5: 4, 8: 5, 9: 6},
+		},
+		{
+			name: "utf8 bom invalid", content: bomUTF8 + "a\xffb", enc: UTF8, exp: "a\uFFFDb",
+			offsets: map[uint32]uint32{0: 3, 1: 4, 4: 5, 5: 6},
+		},
+		{
+			name: "latin1", content: "\xe9 = 1\nb", enc: Latin1, exp: "é = 1\nb",
+			offsets: map[uint32]uint32{0: 0, 2: 1, 6: 5, 7: 6, 8: 7},
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			out, m := transcode(c.content, c.enc)
+			require.Equal(t, c.exp, out)
+			for off, orig := range c.offsets {
+				require.Equal(t, orig, m.original(off), "offset %d", off)
+			}
+		})
+	}
+}
+
+func TestToValidUTF8(t *testing.T) {
+	for in, exp := range map[string]string{
+		"":                "",
+		"abc":             "abc",
+		"a\xffb":          "a\uFFFDb",
+		"a\xff\xfe\xfdb":  "a\uFFFDb",
+		"\xffa\xff":       "\uFFFDa\uFFFD",
+		"\uFFFD\xff":      "\uFFFD\uFFFD",
+		"\xe2\x82x\u20ac": "\uFFFDx\u20ac",
+	} {
+		require.Equal(t, exp, toValidUTF8(in, 0, &offsetMap{}), "%q", in)
+	}
+}
+
+func TestOffsetMap_Positions(t *testing.T) {
+	// "é" is one byte in the original content, but two bytes in UTF-8
+	_, m := transcode("x\n\xe9 = \xe9", Latin1)
+
+	pos := func(off, line, col uint32) nodes.Object {
+		return uast.Position{Offset: off, Line: line, Col: col}.ToObject()
+	}
+	ident := func(start, end nodes.Object) nodes.Object {
+		return nodes.Object{
+			uast.KeyType: nodes.String("uast:Identifier"),
+			uast.KeyPos: nodes.Object{
+				uast.KeyType:  nodes.String(uast.TypePositions),
+				uast.KeyStart: start,
+				uast.KeyEnd:   end,
+			},
+		}
+	}
+	tree := nodes.Array{
+		ident(pos(0, 1, 1), pos(1, 1, 2)),
+		ident(pos(7, 2, 6), pos(9, 2, 8)),
+	}
+	out := m.positions(tree)
+
+	require.Equal(t, nodes.Array{
+		ident(pos(0, 1, 1), pos(1, 1, 2)),
+		ident(pos(6, 2, 5), pos(7, 2, 6)),
+	}, out)
+	// the original tree is not modified
+	require.Equal(t, pos(7, 2, 6), tree[1].(nodes.Object)[uast.KeyPos].(nodes.Object)[uast.KeyStart])
+}
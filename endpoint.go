@@ -0,0 +1,522 @@
+package bblfsh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvEndpoint is an environment variable with an endpoint string, see ParseEndpoint.
+	EnvEndpoint = "BBLFSH_ENDPOINT"
+	// EnvEndpointConfig is an environment variable with a path to an endpoint configuration file.
+	EnvEndpointConfig = "BBLFSH_ENDPOINT_CONFIG"
+	// EnvEndpointLanguagePrefix is a prefix of environment variables that set an endpoint
+	// for a specific language, for example BBLFSH_ENDPOINT_PYTHON=localhost:9432.
+	EnvEndpointLanguagePrefix = EnvEndpoint + "_"
+
+	// templatePlaceholder is replaced by the language name in template endpoints.
+	templatePlaceholder = "%s"
+	// replicaSeparator separates addresses of replicas in endpoint strings.
+	replicaSeparator = "|"
+)
+
+// Endpoint is a single bblfshd or driver endpoint.
+type Endpoint struct {
+	// Address of the endpoint in the gRPC target format.
+	Address string `json:"address" yaml:"address"`
+	// Replicas are addresses of additional backends that serve the same languages as Address.
+	// Requests are balanced between all the backends, see WithLoadBalancing.
+	Replicas []string `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	// TLS overrides the client TLS configuration for this endpoint.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// DialOptions are additional gRPC dial options for this endpoint.
+	// They are applied after the client dial options.
+	DialOptions []grpc.DialOption `json:"-" yaml:"-"`
+}
+
+// newEndpoint creates an endpoint from a list of replica addresses.
+func newEndpoint(addrs []string) *Endpoint {
+	e := &Endpoint{}
+	if len(addrs) != 0 {
+		e.Address = addrs[0]
+	}
+	if len(addrs) > 1 {
+		e.Replicas = addrs[1:]
+	}
+	return e
+}
+
+// parseEndpointAddress parses an address with optional replicas separated by "|".
+func parseEndpointAddress(s string) *Endpoint {
+	addrs := strings.Split(s, replicaSeparator)
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+	return newEndpoint(addrs)
+}
+
+// addresses returns addresses of all the replicas of the endpoint.
+func (e *Endpoint) addresses() []string {
+	return append([]string{e.Address}, e.Replicas...)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts either a string with an address,
+// a list of replica addresses, or an object.
+func (e *Endpoint) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*e = Endpoint{Address: addr}
+		return nil
+	}
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err == nil {
+		*e = *newEndpoint(addrs)
+		return nil
+	}
+	type endpoint Endpoint
+	return unmarshalJSONStrict(data, (*endpoint)(e))
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. It accepts either a string with an address,
+// a list of replica addresses, or a mapping.
+func (e *Endpoint) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var addr string
+	if err := unmarshal(&addr); err == nil {
+		*e = Endpoint{Address: addr}
+		return nil
+	}
+	var addrs []string
+	if err := unmarshal(&addrs); err == nil {
+		*e = *newEndpoint(addrs)
+		return nil
+	}
+	type endpoint Endpoint
+	return unmarshal((*endpoint)(e))
+}
+
+// validate checks addresses of all the replicas of the endpoint.
+func (e *Endpoint) validate(template bool) error {
+	for _, addr := range e.addresses() {
+		var err error
+		if template {
+			err = validateTemplate(addr)
+		} else if err = validateAddress(addr); err == nil && strings.Contains(addr, templatePlaceholder) {
+			err = fmt.Errorf("%q placeholder is only allowed in the template", templatePlaceholder)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EndpointConfig describes how the client connects to bblfshd or language drivers.
+//
+// If only the Default endpoint is set, the client uses a single connection for all languages.
+// Otherwise, a separate connection is dialed for each language: Languages are checked first,
+// then the Template, and then the Default endpoint is used as a fallback.
+//
+// Several languages may share the same Endpoint. When loaded from a file, a key in Languages
+// may list multiple comma-separated languages, and the "*" key sets the Default endpoint.
+type EndpointConfig struct {
+	// Default is an endpoint used for all languages without a specific endpoint.
+	Default *Endpoint `json:"default,omitempty" yaml:"default,omitempty"`
+	// Languages maps a language to its endpoint.
+	Languages map[string]*Endpoint `json:"languages,omitempty" yaml:"languages,omitempty"`
+	// Template is an endpoint with a "%s" placeholder in the address that is replaced by the language.
+	// It cannot be combined with Default.
+	Template *Endpoint `json:"template,omitempty" yaml:"template,omitempty"`
+}
+
+// single checks if the configuration uses a single connection for all languages.
+func (c *EndpointConfig) single() bool {
+	return c.Default != nil && len(c.Default.Replicas) == 0 && c.Template == nil && len(c.Languages) == 0
+}
+
+// resolve returns an endpoint and addresses of its replicas for a given language.
+// It returns false if there is no endpoint for this language.
+func (c *EndpointConfig) resolve(lang string) (*Endpoint, []string, bool) {
+	if e, ok := c.Languages[lang]; ok {
+		return e, e.addresses(), true
+	}
+	if e := c.Template; e != nil {
+		addrs := e.addresses()
+		for i, addr := range addrs {
+			addrs[i] = strings.Replace(addr, templatePlaceholder, lang, 1)
+		}
+		return e, addrs, true
+	}
+	if e := c.Default; e != nil {
+		return e, e.addresses(), true
+	}
+	return nil, nil, false
+}
+
+// Validate checks if the configuration is valid.
+func (c *EndpointConfig) Validate() error {
+	if c.Default == nil && c.Template == nil && len(c.Languages) == 0 {
+		return fmt.Errorf("endpoint config: no endpoints")
+	}
+	if c.Default != nil && c.Template != nil {
+		return fmt.Errorf("endpoint config: cannot combine a default endpoint with a template")
+	}
+	if c.Default != nil {
+		if err := c.Default.validate(false); err != nil {
+			return fmt.Errorf("endpoint config: default: %v", err)
+		}
+	}
+	if c.Template != nil {
+		if err := c.Template.validate(true); err != nil {
+			return fmt.Errorf("endpoint config: template: %v", err)
+		}
+	}
+	for lang, e := range c.Languages {
+		if !isLanguageName(lang) {
+			return fmt.Errorf("endpoint config: invalid language name: %q", lang)
+		}
+		if e == nil {
+			return fmt.Errorf("endpoint config: language %q: empty address", lang)
+		}
+		if err := e.validate(false); err != nil {
+			return fmt.Errorf("endpoint config: language %q: %v", lang, err)
+		}
+	}
+	return nil
+}
+
+func validateAddress(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("empty address")
+	}
+	if i := strings.IndexFunc(addr, unicode.IsSpace); i >= 0 {
+		return fmt.Errorf("address %q contains whitespace at offset %d", addr, i)
+	}
+	return nil
+}
+
+func validateTemplate(addr string) error {
+	if err := validateAddress(addr); err != nil {
+		return err
+	}
+	if !strings.Contains(addr, templatePlaceholder) || strings.Count(addr, "%") != 1 {
+		return fmt.Errorf("template %q must contain exactly one %q placeholder", addr, templatePlaceholder)
+	}
+	return nil
+}
+
+// isLanguageName checks if a string is a valid language identifier.
+func isLanguageName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_+#.", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isHostName checks if a plain endpoint entry is clearly a host name and not a language.
+func isHostName(s string) bool {
+	return s == "localhost" || strings.Contains(s, ".") || net.ParseIP(s) != nil
+}
+
+// EndpointSyntaxError is returned when an endpoint string cannot be parsed.
+type EndpointSyntaxError struct {
+	// Endpoint is the whole endpoint string.
+	Endpoint string
+	// Offset is a byte offset of the invalid entry in the endpoint string.
+	Offset int
+	// Msg describes the error.
+	Msg string
+}
+
+func (e *EndpointSyntaxError) Error() string {
+	return fmt.Sprintf("invalid endpoint %q at offset %d: %s", e.Endpoint, e.Offset, e.Msg)
+}
+
+// ParseEndpoint parses an endpoint string. The following formats are supported:
+//
+//	localhost:9432                            - a single bblfshd server or driver
+//	python=localhost:9432,go=localhost:9433   - comma-separated mapping in format language=address
+//	%s-driver.bblfsh.svc.example.com:9432     - DNS template based on the language
+//
+// Languages in the mapping can share an address by listing them before it:
+//
+//	javascript,typescript=localhost:9433
+//
+// Any address may list several replicas separated by "|", see WithLoadBalancing:
+//
+//	python=py-1:9432|py-2:9432,*=bblfshd:9432
+//
+// A mapping may include a fallback for the languages that are not listed, either as a
+// wildcard entry, a plain address, or a template:
+//
+//	python=localhost:9432,*=bblfshd:9432
+//	python=localhost:9432,bblfshd:9432
+//	python=localhost:9432,%s-driver.bblfsh.svc.example.com:9432
+//
+// A plain name without a port that is followed by a mapping is considered an alias.
+// Names that are clearly host names (localhost, IP addresses or names with dots) are
+// rejected in this position; use the wildcard entry to set the default endpoint instead.
+//
+// Whitespace around entries is ignored.
+func ParseEndpoint(endpoint string) (*EndpointConfig, error) {
+	var (
+		conf EndpointConfig
+		// languages waiting for an address
+		pending     []string
+		pendingOffs []int
+		pendingOff  = -1
+		defaultOff  = -1
+	)
+	synErr := func(off int, format string, args ...interface{}) error {
+		return &EndpointSyntaxError{Endpoint: endpoint, Offset: off, Msg: fmt.Sprintf(format, args...)}
+	}
+	setDefault := func(off int, e *Endpoint) error {
+		if conf.Default != nil {
+			return synErr(off, "multiple default endpoints")
+		}
+		conf.Default = e
+		defaultOff = off
+		return nil
+	}
+	off := 0
+	for _, part := range strings.Split(endpoint, ",") {
+		start := off + len(part) - len(strings.TrimLeftFunc(part, unicode.IsSpace))
+		off += len(part) + 1
+
+		entry := strings.TrimSpace(part)
+		if entry == "" {
+			return nil, synErr(start, "empty entry")
+		}
+		if i := strings.IndexByte(entry, '='); i > 0 {
+			lang := strings.TrimSpace(entry[:i])
+			if lang == "*" || isLanguageName(lang) {
+				e := parseEndpointAddress(entry[i+1:])
+				if err := e.validate(false); err != nil {
+					return nil, synErr(start, "language %q: %v", lang, err)
+				}
+				if lang == "*" {
+					if len(pending) != 0 {
+						return nil, synErr(pendingOff, "wildcard cannot have aliases")
+					}
+					if err := setDefault(start, e); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if conf.Languages == nil {
+					conf.Languages = make(map[string]*Endpoint)
+				}
+				for i, l := range pending {
+					if isHostName(l) {
+						return nil, synErr(pendingOffs[i], "host %q must have a port or be set as a wildcard entry", l)
+					}
+				}
+				for _, l := range append(pending, lang) {
+					if _, ok := conf.Languages[l]; ok {
+						return nil, synErr(start, "duplicate language %q", l)
+					}
+					conf.Languages[l] = e
+				}
+				pending, pendingOffs, pendingOff = nil, nil, -1
+				continue
+			}
+		}
+		if isLanguageName(entry) {
+			// either an alias, or a host name without a port; decided by the next entry
+			if pendingOff < 0 {
+				pendingOff = start
+			}
+			pending = append(pending, entry)
+			pendingOffs = append(pendingOffs, start)
+			continue
+		}
+		if len(pending) != 0 {
+			return nil, synErr(pendingOff, "missing address for %q", pending)
+		}
+		e := parseEndpointAddress(entry)
+		if strings.Contains(entry, "%") {
+			if err := e.validate(true); err != nil {
+				return nil, synErr(start, "%v", err)
+			}
+			if conf.Template != nil {
+				return nil, synErr(start, "multiple templates")
+			}
+			conf.Template = e
+			continue
+		}
+		if err := e.validate(false); err != nil {
+			return nil, synErr(start, "%v", err)
+		}
+		if err := setDefault(start, e); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case len(pending) == 1 && conf.Default == nil:
+		// a host name without a port
+		conf.Default = &Endpoint{Address: pending[0]}
+		defaultOff = pendingOff
+	case len(pending) != 0:
+		return nil, synErr(pendingOff, "missing address for %q", pending)
+	}
+	if conf.Default != nil && conf.Template != nil {
+		return nil, synErr(defaultOff, "cannot combine a default endpoint with a template")
+	}
+	return &conf, nil
+}
+
+// LoadEndpointConfig loads an endpoint configuration from a YAML or JSON file.
+// The format is detected by the file extension; YAML is assumed by default.
+func LoadEndpointConfig(path string) (*EndpointConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf EndpointConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = unmarshalJSONStrict(data, &conf)
+	default:
+		err = yaml.UnmarshalStrict(data, &conf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse endpoint config %q: %v", path, err)
+	}
+	if err = conf.expandAliases(); err != nil {
+		return nil, err
+	}
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+// unmarshalJSONStrict is the same as json.Unmarshal, but rejects unknown fields,
+// like yaml.UnmarshalStrict.
+func unmarshalJSONStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// EndpointConfigFromEnv loads an endpoint configuration from environment variables.
+//
+// The configuration file set in BBLFSH_ENDPOINT_CONFIG is loaded first, then the BBLFSH_ENDPOINT
+// string is parsed, and then the BBLFSH_ENDPOINT_<LANGUAGE> variables override endpoints
+// for specific languages. Language names are converted to lower case. A default endpoint set
+// in BBLFSH_ENDPOINT replaces the template from the file, and the other way around.
+//
+// It returns nil if none of the variables are set.
+func EndpointConfigFromEnv() (*EndpointConfig, error) {
+	var conf *EndpointConfig
+	if path := os.Getenv(EnvEndpointConfig); path != "" {
+		c, err := LoadEndpointConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		conf = c
+	}
+	if s := os.Getenv(EnvEndpoint); s != "" {
+		c, err := ParseEndpoint(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", EnvEndpoint, err)
+		}
+		if conf == nil {
+			conf = c
+		} else {
+			conf.merge(c)
+		}
+	}
+	for _, kv := range os.Environ() {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv[:i], EnvEndpointLanguagePrefix) || kv[:i] == EnvEndpointConfig {
+			continue
+		}
+		lang := strings.ToLower(kv[len(EnvEndpointLanguagePrefix):i])
+		if conf == nil {
+			conf = &EndpointConfig{}
+		}
+		if conf.Languages == nil {
+			conf.Languages = make(map[string]*Endpoint)
+		}
+		conf.Languages[lang] = parseEndpointAddress(kv[i+1:])
+	}
+	if conf == nil {
+		return nil, nil
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// expandAliases splits language keys that list multiple comma-separated languages,
+// and moves the wildcard "*" entry to the default endpoint.
+func (c *EndpointConfig) expandAliases() error {
+	if len(c.Languages) == 0 {
+		return nil
+	}
+	langs := make(map[string]*Endpoint, len(c.Languages))
+	add := func(lang string, e *Endpoint) error {
+		if _, ok := langs[lang]; ok {
+			return fmt.Errorf("endpoint config: duplicate language %q", lang)
+		}
+		langs[lang] = e
+		return nil
+	}
+	for key, e := range c.Languages {
+		switch {
+		case key == "*":
+			if c.Default != nil {
+				return fmt.Errorf("endpoint config: both default and wildcard endpoints are set")
+			}
+			c.Default = e
+		case strings.Contains(key, ","):
+			for _, lang := range strings.Split(key, ",") {
+				if err := add(strings.TrimSpace(lang), e); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := add(key, e); err != nil {
+				return err
+			}
+		}
+	}
+	c.Languages = langs
+	return nil
+}
+
+// merge overrides endpoints in c with the ones set in c2. The default endpoint and the template
+// cannot be combined, so setting one of them in c2 replaces the other one.
+func (c *EndpointConfig) merge(c2 *EndpointConfig) {
+	if c2.Default != nil {
+		c.Default, c.Template = c2.Default, nil
+	}
+	if c2.Template != nil {
+		c.Default, c.Template = nil, c2.Template
+	}
+	for lang, e := range c2.Languages {
+		if c.Languages == nil {
+			c.Languages = make(map[string]*Endpoint)
+		}
+		c.Languages[lang] = e
+	}
+}
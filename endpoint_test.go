@@ -0,0 +1,395 @@
+package bblfsh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bblfsh/sdk/v3/driver"
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	for _, c := range []struct {
+		endpoint string
+		exp      EndpointConfig
+	}{
+		{
+			endpoint: "localhost:9432",
+			exp:      EndpointConfig{Default: &Endpoint{Address: "localhost:9432"}},
+		},
+		{
+			endpoint: "  dns:///bblfshd.svc:9432?lb=rr ",
+			exp:      EndpointConfig{Default: &Endpoint{Address: "dns:///bblfshd.svc:9432?lb=rr"}},
+		},
+		{
+			endpoint: "python=localhost:9432",
+			exp: EndpointConfig{Languages: map[string]*Endpoint{
+				"python": {Address: "localhost:9432"},
+			}},
+		},
+		{
+			endpoint: "python = localhost:9432, go=unix:///tmp/go.sock?a=b , c++=cpp:9432",
+			exp: EndpointConfig{Languages: map[string]*Endpoint{
+				"python": {Address: "localhost:9432"},
+				"go":     {Address: "unix:///tmp/go.sock?a=b"},
+				"c++":    {Address: "cpp:9432"},
+			}},
+		},
+		{
+			endpoint: "%s-driver.bblfsh.svc.example.com:9432",
+			exp:      EndpointConfig{Template: &Endpoint{Address: "%s-driver.bblfsh.svc.example.com:9432"}},
+		},
+		{
+			endpoint: "localhost",
+			exp:      EndpointConfig{Default: &Endpoint{Address: "localhost"}},
+		},
+		{
+			endpoint: "python=a:1, b:1",
+			exp: EndpointConfig{
+				Default:   &Endpoint{Address: "b:1"},
+				Languages: map[string]*Endpoint{"python": {Address: "a:1"}},
+			},
+		},
+		{
+			endpoint: "python=a:1,localhost",
+			exp: EndpointConfig{
+				Default:   &Endpoint{Address: "localhost"},
+				Languages: map[string]*Endpoint{"python": {Address: "a:1"}},
+			},
+		},
+		{
+			endpoint: "*=b:1,python=a:1",
+			exp: EndpointConfig{
+				Default:   &Endpoint{Address: "b:1"},
+				Languages: map[string]*Endpoint{"python": {Address: "a:1"}},
+			},
+		},
+		{
+			endpoint: "python=a:1,%s-driver:9432",
+			exp: EndpointConfig{
+				Template:  &Endpoint{Address: "%s-driver:9432"},
+				Languages: map[string]*Endpoint{"python": {Address: "a:1"}},
+			},
+		},
+		{
+			endpoint: "python=a:1, javascript, typescript=c:1, go=d:1",
+			exp: EndpointConfig{Languages: map[string]*Endpoint{
+				"python":     {Address: "a:1"},
+				"javascript": {Address: "c:1"},
+				"typescript": {Address: "c:1"},
+				"go":         {Address: "d:1"},
+			}},
+		},
+	} {
+		c := c
+		t.Run(c.endpoint, func(t *testing.T) {
+			conf, err := ParseEndpoint(c.endpoint)
+			require.NoError(t, err)
+			require.Equal(t, c.exp, *conf)
+			require.NoError(t, conf.Validate())
+		})
+	}
+}
+
+func TestParseEndpointErrors(t *testing.T) {
+	for _, c := range []struct {
+		endpoint string
+		offset   int
+	}{
+		{endpoint: "", offset: 0},
+		{endpoint: "python=a:1,,go=b:1", offset: 11},
+		{endpoint: "python=a:1, go=", offset: 12},
+		{endpoint: "python=a:1,go=b :1", offset: 11},
+		{endpoint: "python=a:1, python=b:1", offset: 12},
+		{endpoint: "local host:9432", offset: 0},
+		{endpoint: "%s-%s.svc:9432", offset: 0},
+		{endpoint: "%d.svc:9432", offset: 0},
+		{endpoint: "a:1, b:1", offset: 5},
+		{endpoint: "python=a:1, *=b:1, c:1", offset: 19},
+		{endpoint: "javascript, typescript", offset: 0},
+		{endpoint: "python=a:1, javascript, b:1", offset: 12},
+		{endpoint: "go, *=b:1", offset: 0},
+		{endpoint: "go, python=a:1, go=b:1", offset: 16},
+		{endpoint: "a:1,%s.svc:1", offset: 0},
+		{endpoint: "localhost,python=a:1", offset: 0},
+		{endpoint: "go, bblfshd.svc, python=a:1", offset: 4},
+		{endpoint: "127.0.0.1,python=a:1", offset: 0},
+	} {
+		c := c
+		t.Run(c.endpoint, func(t *testing.T) {
+			_, err := ParseEndpoint(c.endpoint)
+			require.Error(t, err)
+			e, ok := err.(*EndpointSyntaxError)
+			require.True(t, ok, "%T", err)
+			require.Equal(t, c.offset, e.Offset, e.Error())
+			require.Equal(t, c.endpoint, e.Endpoint)
+		})
+	}
+}
+
+const testEndpointYAML = `
+template:
+  address: "%s-driver:9432"
+  tls:
+    server_name: bblfsh.test
+languages:
+  python: python-driver:9432
+  go:
+    address: go-driver:9432
+    tls:
+      ca_file: /etc/ssl/ca.pem
+`
+
+const testEndpointJSON = `{
+	"template": {"address": "%s-driver:9432", "tls": {"server_name": "bblfsh.test"}},
+	"languages": {
+		"python": "python-driver:9432",
+		"go": {"address": "go-driver:9432", "tls": {"ca_file": "/etc/ssl/ca.pem"}}
+	}
+}`
+
+func TestLoadEndpointConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bblfsh-endpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	exp := EndpointConfig{
+		Template: &Endpoint{Address: "%s-driver:9432", TLS: &TLSConfig{ServerName: "bblfsh.test"}},
+		Languages: map[string]*Endpoint{
+			"python": {Address: "python-driver:9432"},
+			"go":     {Address: "go-driver:9432", TLS: &TLSConfig{CAFile: "/etc/ssl/ca.pem"}},
+		},
+	}
+	for name, data := range map[string]string{
+		"endpoints.yml":  testEndpointYAML,
+		"endpoints.json": testEndpointJSON,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+
+		conf, err := LoadEndpointConfig(path)
+		require.NoError(t, err, name)
+		require.Equal(t, exp, *conf, name)
+	}
+
+	path := filepath.Join(dir, "aliases.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+languages:
+  "*": localhost:9432
+  javascript, typescript: js-driver:9432
+`), 0644))
+	conf, err := LoadEndpointConfig(path)
+	require.NoError(t, err)
+	js := &Endpoint{Address: "js-driver:9432"}
+	require.Equal(t, EndpointConfig{
+		Default:   &Endpoint{Address: "localhost:9432"},
+		Languages: map[string]*Endpoint{"javascript": js, "typescript": js},
+	}, *conf)
+
+	path = filepath.Join(dir, "duplicate.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+languages:
+  javascript: js-driver:9432
+  javascript, typescript: js-driver:9432
+`), 0644))
+	_, err = LoadEndpointConfig(path)
+	require.Error(t, err)
+
+	path = filepath.Join(dir, "invalid.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("languages:\n  python: ''\n"), 0644))
+	_, err = LoadEndpointConfig(path)
+	require.Error(t, err)
+
+	// unknown fields, the default endpoint combined with a template
+	for name, data := range map[string]string{
+		"unknown.yml":       "defualt: localhost:9432\n",
+		"unknown.json":      `{"defualt": "localhost:9432"}`,
+		"unknown-tls.json":  `{"default": {"address": "localhost:9432", "tsl": {"insecure": true}}}`,
+		"both.yml":          "default: localhost:9432\ntemplate: '%s-driver:9432'\n",
+		"both.json":         `{"default": "localhost:9432", "template": "%s-driver:9432"}`,
+		"both-wildcard.yml": "template: '%s-driver:9432'\nlanguages:\n  '*': localhost:9432\n",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+		_, err = LoadEndpointConfig(path)
+		require.Error(t, err, name)
+	}
+
+	require.Error(t, (&EndpointConfig{
+		Default:  &Endpoint{Address: "localhost:9432"},
+		Template: &Endpoint{Address: "%s-driver:9432"},
+	}).Validate())
+}
+
+func TestEndpointConfigFromEnv(t *testing.T) {
+	conf, err := EndpointConfigFromEnv()
+	require.NoError(t, err)
+	require.Nil(t, conf)
+
+	os.Setenv(EnvEndpoint, "localhost:9432")
+	os.Setenv(EnvEndpointLanguagePrefix+"PYTHON", "python-driver:9432")
+	defer func() {
+		os.Unsetenv(EnvEndpoint)
+		os.Unsetenv(EnvEndpointLanguagePrefix + "PYTHON")
+	}()
+
+	conf, err = EndpointConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, EndpointConfig{
+		Default: &Endpoint{Address: "localhost:9432"},
+		Languages: map[string]*Endpoint{
+			"python": {Address: "python-driver:9432"},
+		},
+	}, *conf)
+
+	// the default endpoint replaces the template from the file
+	dir, err := ioutil.TempDir("", "bblfsh-endpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "endpoints.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testEndpointYAML), 0644))
+	os.Setenv(EnvEndpointConfig, path)
+	defer os.Unsetenv(EnvEndpointConfig)
+
+	conf, err = EndpointConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, &Endpoint{Address: "localhost:9432"}, conf.Default)
+	require.Nil(t, conf.Template)
+	require.Equal(t, &Endpoint{Address: "python-driver:9432"}, conf.Languages["python"])
+	require.Equal(t, "go-driver:9432", conf.Languages["go"].Address)
+}
+
+func TestEndpointConfig_Resolve(t *testing.T) {
+	conf := &EndpointConfig{
+		Languages: map[string]*Endpoint{"python": {Address: "python:9432"}},
+		Template:  &Endpoint{Address: "%s-driver:9432", Replicas: []string{"%s-driver-2:9432"}},
+	}
+	_, addrs, ok := conf.resolve("python")
+	require.True(t, ok)
+	require.Equal(t, []string{"python:9432"}, addrs)
+
+	_, addrs, ok = conf.resolve("go")
+	require.True(t, ok)
+	require.Equal(t, []string{"go-driver:9432", "go-driver-2:9432"}, addrs)
+
+	conf.Template = nil
+	_, _, ok = conf.resolve("go")
+	require.False(t, ok)
+
+	conf.Default = &Endpoint{Address: "bblfshd:9432"}
+	_, addrs, ok = conf.resolve("go")
+	require.True(t, ok)
+	require.Equal(t, []string{"bblfshd:9432"}, addrs)
+}
+
+func TestParseEndpoint_Replicas(t *testing.T) {
+	conf, err := ParseEndpoint("python=py-1:9432 | py-2:9432,bblfshd-1:9432|bblfshd-2:9432")
+	require.NoError(t, err)
+	require.Equal(t, EndpointConfig{
+		Default: &Endpoint{Address: "bblfshd-1:9432", Replicas: []string{"bblfshd-2:9432"}},
+		Languages: map[string]*Endpoint{
+			"python": {Address: "py-1:9432", Replicas: []string{"py-2:9432"}},
+		},
+	}, *conf)
+	require.False(t, conf.single())
+
+	conf, err = ParseEndpoint("%s-1:9432|%s-2:9432")
+	require.NoError(t, err)
+	require.Equal(t, EndpointConfig{
+		Template: &Endpoint{Address: "%s-1:9432", Replicas: []string{"%s-2:9432"}},
+	}, *conf)
+
+	_, err = ParseEndpoint("python=py-1:9432|")
+	require.Error(t, err)
+
+	_, err = ParseEndpoint("%s-1:9432|bblfshd:9432")
+	require.Error(t, err)
+}
+
+func TestLoadEndpointConfig_Replicas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bblfsh-endpoints")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "endpoints.yml")
+	err = ioutil.WriteFile(path, []byte(`
+languages:
+  python: [py-1:9432, py-2:9432]
+  go:
+    address: go-1:9432
+    replicas: [go-2:9432]
+`), 0644)
+	require.NoError(t, err)
+
+	conf, err := LoadEndpointConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, EndpointConfig{
+		Languages: map[string]*Endpoint{
+			"python": {Address: "py-1:9432", Replicas: []string{"py-2:9432"}},
+			"go":     {Address: "go-1:9432", Replicas: []string{"go-2:9432"}},
+		},
+	}, *conf)
+
+	path = filepath.Join(dir, "endpoints.json")
+	err = ioutil.WriteFile(path, []byte(`{"default": ["a:9432", "b:9432"]}`), 0644)
+	require.NoError(t, err)
+
+	conf, err = LoadEndpointConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, EndpointConfig{
+		Default: &Endpoint{Address: "a:9432", Replicas: []string{"b:9432"}},
+	}, *conf)
+}
+
+func TestNewClientWithEndpoints(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cli, err := NewClientWithEndpointsContext(ctx, &EndpointConfig{
+		Languages: map[string]*Endpoint{"python": {Address: addr}},
+	})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	resp, err := cli.NewParseRequest().Context(ctx).Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	require.Equal(t, "python", resp.Language)
+
+	_, err = cli.NewParseRequest().Context(ctx).Language("go").Content("foo").Do()
+	require.True(t, driver.IsMissingDriver(parseErrCause(t, err)), "%v", err)
+
+	// fallback to the default endpoint
+	var fallback int32
+	addr2, stop2 := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			atomic.AddInt32(&fallback, 1)
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	})
+	defer stop2()
+
+	cli2, err := NewClientContext(ctx, "python,javascript="+addr+","+addr2)
+	require.NoError(t, err)
+	defer cli2.Close()
+
+	for _, lang := range []string{"python", "javascript", "go"} {
+		_, err = cli2.NewParseRequest().Context(ctx).Language(lang).Content("foo").Do()
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&fallback))
+
+	// invalid TLS settings are reported early
+	_, err = NewClientWithEndpointsContext(ctx, &EndpointConfig{
+		Languages: map[string]*Endpoint{"python": {Address: addr, TLS: &TLSConfig{CAFile: "NO_EXISTS"}}},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package bblfsh
+
+import (
+	"context"
+	"fmt"
+
+	derrors "github.com/bblfsh/sdk/v3/driver/errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrDriverFailure is returned when the driver is malfunctioning.
+	ErrDriverFailure = derrors.ErrDriverFailure
+
+	// ErrSyntax is returned when driver cannot parse the source file.
+	// Can be omitted for native driver implementations.
+	ErrSyntax = derrors.ErrSyntax
+)
+
+// ParseError is returned by parse requests. The error reported by the driver is kept
+// in the Err field, so its kind can be checked with ErrSyntax.Is(perr.Err).
+type ParseError struct {
+	// Language of the request. It's empty if the language was not set, nor detected.
+	Language string
+	// Filename of the request.
+	Filename string
+	// Code is the gRPC status code of the request. It's codes.OK if the server responded,
+	// but the driver reported syntax errors.
+	Code codes.Code
+	// Messages are individual error messages.
+	Messages []string
+	// Errors are syntax errors with their positions, if the driver reported any.
+	Errors SyntaxErrors
+	// Retryable is set if the request may succeed if sent again.
+	Retryable bool
+	// Timeout tells which deadline was exceeded, if the request timed out.
+	Timeout TimeoutSource
+	// Err is the underlying error.
+	Err error
+
+	// raw is the error returned by the RPC, if any
+	raw error
+}
+
+func (e *ParseError) Error() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("%s: %v", e.Filename, e.Err)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the error, which allows to use status.Code with it.
+func (e *ParseError) GRPCStatus() *status.Status {
+	return errorStatus(e.raw, e.Code, e.Error())
+}
+
+// newParseError creates a parse error. Raw is the error returned by the parse RPC, if any,
+// and err is the error that should be reported.
+func newParseError(r *ParseRequest, lang string, raw, err error, errs SyntaxErrors) *ParseError {
+	if pe, ok := err.(*ParseError); ok {
+		return pe
+	}
+	pe := &ParseError{
+		Language:  lang,
+		Filename:  r.options.Filename,
+		Code:      errorCode(raw),
+		Errors:    errs,
+		Retryable: isRetryable(raw),
+		Err:       err,
+		raw:       raw,
+	}
+	if raw == nil && errs == nil {
+		// not a server error
+		pe.Code = errorCode(err)
+	}
+	if pe.Code == codes.DeadlineExceeded {
+		pe.Timeout = r.timedOut
+		if pe.Timeout == NoTimeout {
+			// the context expired before sending the request
+			pe.Timeout = ContextTimeout
+		}
+		if pe.Timeout == ContextTimeout {
+			pe.Retryable = false
+		}
+	}
+	if len(errs) != 0 {
+		for _, se := range errs {
+			pe.Messages = append(pe.Messages, se.Message)
+		}
+	} else {
+		pe.Messages = errorMessages(err)
+	}
+	return pe
+}
+
+// FileTooLargeError is returned when the content of a parse request exceeds the maximal size.
+// The request is not sent in this case.
+type FileTooLargeError struct {
+	// Filename of the request, if set.
+	Filename string
+	// Size of the content. If the size was not known in advance, it's the number
+	// of bytes read before the limit was exceeded.
+	Size int64
+	// MaxSize is the maximal allowed size.
+	MaxSize int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	name := e.Filename
+	if name == "" {
+		name = "content"
+	}
+	return fmt.Sprintf("%s is too large: %d bytes, max %d", name, e.Size, e.MaxSize)
+}
+
+// ServerError is returned by version and supported languages requests.
+type ServerError struct {
+	// Op is the name of the failed request.
+	Op string
+	// Code is the gRPC status code of the request.
+	Code codes.Code
+	// Messages are individual error messages.
+	Messages []string
+	// Retryable is set if the request may succeed if sent again.
+	Retryable bool
+	// Timeout tells which deadline was exceeded, if the request timed out.
+	Timeout TimeoutSource
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// GRPCStatus returns the gRPC status of the error, which allows to use status.Code with it.
+func (e *ServerError) GRPCStatus() *status.Status {
+	return errorStatus(e.Err, e.Code, e.Error())
+}
+
+func newServerError(op string, err error) *ServerError {
+	return &ServerError{
+		Op:        op,
+		Code:      errorCode(err),
+		Messages:  errorMessages(err),
+		Retryable: isRetryable(err),
+		Err:       err,
+	}
+}
+
+// setTimeout records the source of the timeout. Requests that exceeded the context deadline
+// are not retryable.
+func (e *ServerError) setTimeout(s TimeoutSource) {
+	e.Timeout = s
+	if s == ContextTimeout {
+		e.Retryable = false
+	}
+}
+
+// errorCode returns a gRPC status code for an error.
+func errorCode(err error) codes.Code {
+	switch err {
+	case nil:
+		return codes.OK
+	case context.Canceled:
+		return codes.Canceled
+	case context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	}
+	return status.Code(err)
+}
+
+// errorStatus returns the status of the raw RPC error, or creates a new one.
+// Errors that are not associated with a failed RPC are reported with the Unknown code.
+func errorStatus(raw error, code codes.Code, msg string) *status.Status {
+	if s, ok := status.FromError(raw); ok && raw != nil {
+		return s
+	}
+	if code == codes.OK {
+		code = codes.Unknown
+	}
+	return status.New(code, msg)
+}
+
+// errorMessages splits an error into individual messages.
+func errorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if s, ok := status.FromError(err); ok {
+		return []string{s.Message()}
+	}
+	var multi *derrors.ErrMulti
+	if e, ok := err.(interface{ Cause() error }); ok {
+		// errors of a specific kind, like ErrSyntax
+		if m, ok := e.Cause().(*derrors.ErrMulti); ok {
+			multi = m
+		}
+	} else if m, ok := err.(*derrors.ErrMulti); ok {
+		multi = m
+	}
+	if multi == nil {
+		return []string{err.Error()}
+	}
+	out := make([]string, 0, len(multi.Errors))
+	for _, e := range multi.Errors {
+		out = append(out, e.Error())
+	}
+	return out
+}
+
+// isRetryable checks if the request that failed with the error may succeed if sent again.
+func isRetryable(err error) bool {
+	p := DefaultRetryPolicy()
+	return p.retryable(err)
+}
@@ -0,0 +1,136 @@
+package bblfsh
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parseErrCause returns the error wrapped by a ParseError.
+func parseErrCause(t testing.TB, err error) error {
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	return perr.Err
+}
+
+func TestParseError_Server(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			return nil, status.Error(codes.Unavailable, "driver is starting")
+		},
+	})
+	defer stop()
+
+	newReq := func() *ParseRequest {
+		return cli.NewParseRequest().Language("python").Filename("foo.py").Content("x")
+	}
+	_, errDo := newReq().Do()
+	_, _, errUAST := newReq().UAST()
+	_, errResult := newReq().Result()
+	for _, err := range []error{errDo, errUAST, errResult} {
+		perr, ok := err.(*ParseError)
+		require.True(t, ok, "%T", err)
+		require.Equal(t, "python", perr.Language)
+		require.Equal(t, "foo.py", perr.Filename)
+		require.Equal(t, codes.Unavailable, perr.Code)
+		require.Equal(t, []string{"driver is starting"}, perr.Messages)
+		require.True(t, perr.Retryable)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		require.Equal(t, codes.Unavailable, status.Code(perr.Unwrap()))
+	}
+}
+
+func TestParseError_Syntax(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: "python", Errors: []*protocol2.ParseError{
+				{Text: "line 1: foo"}, {Text: "bar"},
+			}}, nil
+		},
+	})
+	defer stop()
+
+	// syntax errors are reported in the response
+	resp, err := cli.NewParseRequest().Content("x").Do()
+	require.NoError(t, err)
+	require.Len(t, resp.Errors, 2)
+
+	_, _, errUAST := cli.NewParseRequest().Content("x").UAST()
+	_, errResult := cli.NewParseRequest().Content("x").Result()
+	for _, err := range []error{errUAST, errResult} {
+		perr, ok := err.(*ParseError)
+		require.True(t, ok, "%T", err)
+		require.True(t, ErrSyntax.Is(perr.Err))
+		require.False(t, ErrDriverFailure.Is(perr.Err))
+		require.Equal(t, "python", perr.Language)
+		require.Equal(t, codes.OK, perr.Code)
+		require.Equal(t, []string{"line 1: foo", "bar"}, perr.Messages)
+		require.Len(t, perr.Errors, 2)
+		require.Equal(t, uint32(1), perr.Errors[0].Position.Line)
+		require.False(t, perr.Retryable)
+		require.Equal(t, codes.Unknown, status.Code(err))
+	}
+}
+
+func TestParseError_Local(t *testing.T) {
+	_, errDo := (&ParseRequest{}).ReadFile("NO_EXISTS").Do()
+	_, _, errUAST := (&ParseRequest{}).ReadFile("NO_EXISTS").UAST()
+	_, errResult := (&ParseRequest{}).ReadFile("NO_EXISTS").Result()
+	for _, err := range []error{errDo, errUAST, errResult} {
+		perr, ok := err.(*ParseError)
+		require.True(t, ok, "%T", err)
+		require.True(t, os.IsNotExist(perr.Err))
+		require.Equal(t, "", perr.Filename)
+		require.Equal(t, codes.Unknown, perr.Code)
+		require.Len(t, perr.Messages, 1)
+		require.False(t, perr.Retryable)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{})
+	defer stop()
+
+	// canceled requests fail on the client side
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := cli.NewVersionRequest().Context(ctx).Do()
+	serr, ok := err.(*ServerError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, "version", serr.Op)
+	require.Equal(t, codes.Canceled, serr.Code)
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Equal(t, codes.Canceled, status.Code(serr.Unwrap()))
+
+	_, err = cli.NewSupportedLanguagesRequest().Context(ctx).DoV2()
+	serr, ok = err.(*ServerError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, "supported languages", serr.Op)
+	require.Equal(t, codes.Canceled, serr.Code)
+}
+
+func TestServerError_Server(t *testing.T) {
+	srv := &mockServer{}
+	cli, stop := newMockClient(t, srv)
+	defer stop()
+
+	// the client requests the version when connecting
+	srv.hostErr = status.Error(codes.Unavailable, "starting")
+	_, errVersion := cli.NewVersionRequest().Do()
+	_, errLangs := cli.NewSupportedLanguagesRequest().DoV2()
+	for _, err := range []error{errVersion, errLangs} {
+		serr, ok := err.(*ServerError)
+		require.True(t, ok, "%T", err)
+		require.Equal(t, codes.Unavailable, serr.Code)
+		require.Equal(t, []string{"starting"}, serr.Messages)
+		require.True(t, serr.Retryable)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	}
+}
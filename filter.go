@@ -0,0 +1,167 @@
+package bblfsh
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxBinaryCheckBytes is the number of bytes checked for NUL bytes by SkipBinary.
+	maxBinaryCheckBytes = 8000
+	// maxGeneratedCheckBytes is the number of bytes checked for generated code markers.
+	maxGeneratedCheckBytes = 4 * 1024
+)
+
+// SourceFile is a file checked by filters before parsing.
+type SourceFile struct {
+	// Path of the file, if it was read from a file system. Otherwise, it's the filename.
+	Path string
+	// Filename of the request.
+	Filename string
+	// Language of the request, if set or detected.
+	Language string
+	// Content of the file. It's already transcoded to UTF-8 if the encoding was set for the request.
+	Content string
+}
+
+// Filter checks a file before parsing. It returns a non-empty reason if the file should be skipped.
+//
+// Skipped files are not sent to the server. They are reported with ParseResult.Skipped
+// by Result and batch requests, and with SkippedError by UAST and Do.
+type Filter func(f SourceFile) string
+
+// SkippedError is returned by ParseRequest.UAST and ParseRequest.Do when the file is rejected
+// by a filter. The request is not sent in this case.
+type SkippedError struct {
+	// Filename of the request, if set.
+	Filename string
+	// Reason returned by the filter.
+	Reason string
+}
+
+func (e *SkippedError) Error() string {
+	name := e.Filename
+	if name == "" {
+		name = "content"
+	}
+	return fmt.Sprintf("%s is skipped: %s", name, e.Reason)
+}
+
+// IsSkipped checks if the error is a SkippedError.
+func IsSkipped(err error) bool {
+	_, ok := err.(*SkippedError)
+	return ok
+}
+
+// DefaultFilters returns filters that skip binary files, files larger than 1MB,
+// files with lines longer than 1000 bytes, vendored and generated files.
+func DefaultFilters() []Filter {
+	return []Filter{
+		SkipBinary(),
+		SkipLargerThan(1 << 20),
+		SkipLongLines(1000),
+		SkipVendored(),
+		SkipGenerated(),
+	}
+}
+
+// SkipBinary skips files with NUL bytes in the beginning of the content.
+func SkipBinary() Filter {
+	return func(f SourceFile) string {
+		content := f.Content
+		if len(content) > maxBinaryCheckBytes {
+			content = content[:maxBinaryCheckBytes]
+		}
+		if strings.IndexByte(content, 0) >= 0 {
+			return "binary content"
+		}
+		return ""
+	}
+}
+
+// SkipLargerThan skips files larger than a given number of bytes.
+func SkipLargerThan(n int64) Filter {
+	return func(f SourceFile) string {
+		if size := int64(len(f.Content)); size > n {
+			return fmt.Sprintf("file size %d exceeds %d bytes", size, n)
+		}
+		return ""
+	}
+}
+
+// SkipLongLines skips files with lines longer than a given number of bytes,
+// which is typical for minified code.
+func SkipLongLines(n int) Filter {
+	return func(f SourceFile) string {
+		content := f.Content
+		for len(content) > n {
+			i := strings.IndexByte(content, '\n')
+			if i < 0 {
+				i = len(content)
+			}
+			if i > n {
+				return fmt.Sprintf("line length %d exceeds %d bytes", i, n)
+			}
+			content = content[i+1:]
+		}
+		return ""
+	}
+}
+
+// vendoredPath matches directories with third-party code.
+var vendoredPath = regexp.MustCompile(`(^|/)(vendor|vendors|_vendor|node_modules|bower_components|third_party|3rdparty|Godeps|Pods|Carthage)/`)
+
+// SkipVendored skips files in directories with third-party code, such as vendor or node_modules.
+func SkipVendored() Filter {
+	return func(f SourceFile) string {
+		if vendoredPath.MatchString(filepath.ToSlash(f.Path)) {
+			return "vendored file"
+		}
+		return ""
+	}
+}
+
+var (
+	// generatedPath matches names of files that are usually generated.
+	generatedPath = regexp.MustCompile(`(\.pb\.go|\.pb\.gw\.go|_pb2(_grpc)?\.py|\.min\.(js|css)|\.designer\.cs|^zz_generated\.[^/]+\.go|^package-lock\.json|^yarn\.lock)$`)
+	// generatedContent matches header lines of generated code: the Go convention and comments
+	// starting with the "@generated" marker.
+	generatedContent = regexp.MustCompile(`(?m)^(?:// Code generated .* DO NOT EDIT\.|\s*(?://+|#+|/?\*+|--)\s*@generated\b.*)\r?$`)
+)
+
+// SkipGenerated skips generated files, detected by well-known file names and header lines
+// like "// Code generated ... DO NOT EDIT." or "/* @generated */" in the beginning of the file.
+// Such phrases in the middle of a line, for example in string literals, are ignored.
+func SkipGenerated() Filter {
+	return func(f SourceFile) string {
+		name := f.Filename
+		if name == "" {
+			name = filepath.Base(f.Path)
+		}
+		if generatedPath.MatchString(name) {
+			return "generated file"
+		}
+		content := f.Content
+		if len(content) > maxGeneratedCheckBytes {
+			content = content[:maxGeneratedCheckBytes]
+		}
+		if generatedContent.MatchString(content) {
+			return "generated file"
+		}
+		return ""
+	}
+}
+
+// skip runs filters on the file and returns the first non-empty reason to skip it.
+func skip(f SourceFile, filters ...[]Filter) string {
+	for _, list := range filters {
+		for _, fnc := range list {
+			if reason := fnc(f); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,41 @@
+package bblfsh
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultFilters(t *testing.T) {
+	cases := []struct {
+		name string
+		file SourceFile
+		skip string
+	}{
+		{"source", SourceFile{Path: "pkg/foo.go", Filename: "foo.go", Content: "package foo\n"}, ""},
+		{"binary", SourceFile{Path: "foo.py", Content: "x\x00y"}, "binary content"},
+		{"large", SourceFile{Path: "foo.py", Content: strings.Repeat("x = 1\n", 200000)}, "file size 1200000 exceeds 1048576 bytes"},
+		{"minified", SourceFile{Path: "app.js", Content: "var a;\n" + strings.Repeat("a", 2000) + "\n"}, "line length 2000 exceeds 1000 bytes"},
+		{"last line", SourceFile{Path: "app.js", Content: "var a;\n" + strings.Repeat("a", 1001)}, "line length 1001 exceeds 1000 bytes"},
+		{"vendor", SourceFile{Path: "vendor/github.com/foo/foo.go", Content: "package foo\n"}, "vendored file"},
+		{"node_modules", SourceFile{Path: `src/node_modules/foo/index.js`, Content: "x"}, "vendored file"},
+		{"not vendor", SourceFile{Path: "myvendor/foo.go", Content: "package foo\n"}, ""},
+		{"generated name", SourceFile{Path: "api/api.pb.go", Filename: "api.pb.go", Content: "package api\n"}, "generated file"},
+		{"minified name", SourceFile{Path: "jquery.min.js", Content: "x"}, "generated file"},
+		{"generated go", SourceFile{Path: "foo.go", Content: "// Code generated by stringer. DO NOT EDIT.\n\npackage foo\n"}, "generated file"},
+		{"generated marker", SourceFile{Path: "Foo.java", Content: "/* @generated */\nclass Foo {}\n"}, "generated file"},
+		{"generated python", SourceFile{Path: "foo.py", Content: "#!/usr/bin/env python\n# @generated by tool\r\nx = 1\n"}, "generated file"},
+		{"mentions do not edit", SourceFile{Path: "foo.py", Content: "# Please do not edit the list below by hand.\nx = 1\n"}, ""},
+		{"mentions auto-generated", SourceFile{Path: "foo.go", Content: "package foo\n\n// Parse reads auto-generated files.\nfunc Parse() {}\n"}, ""},
+		{"go marker in string", SourceFile{Path: "gen.go", Content: "package gen\n\nconst header = \"// Code generated by gen. DO NOT EDIT.\"\n"}, ""},
+		{"marker in comment", SourceFile{Path: "Foo.java", Content: "// files with the @generated marker are skipped\nclass Foo {}\n"}, ""},
+	}
+	filters := DefaultFilters()
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.skip, skip(c.file, filters))
+		})
+	}
+}
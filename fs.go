@@ -0,0 +1,32 @@
+package bblfsh
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// FS is a read-only file system, such as an archive or a git object store.
+// It mirrors fs.FS from newer Go versions.
+type FS interface {
+	// Open opens the named file. Names are slash-separated paths.
+	Open(name string) (File, error)
+}
+
+// File is a file opened from FS.
+type File interface {
+	io.Reader
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// Dir is an FS that reads files from a directory on the local file system.
+// Names cannot refer to files outside of the directory.
+type Dir string
+
+// Open implements FS.
+func (d Dir) Open(name string) (File, error) {
+	name = filepath.FromSlash(path.Clean("/" + name))
+	return os.Open(filepath.Join(string(d), name))
+}
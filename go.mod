@@ -8,4 +8,5 @@ require (
 	github.com/stretchr/testify v1.3.0
 	google.golang.org/grpc v1.20.1
 	gopkg.in/bblfsh/sdk.v1 v1.17.0
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -0,0 +1,175 @@
+package bblfsh
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthInterval  = 30 * time.Second
+	defaultHealthTimeout   = 5 * time.Second
+	defaultHealthThreshold = 2
+)
+
+// HealthCheck configures health checks of per-language connections of a multi-endpoint client.
+//
+// Connections are checked using the gRPC health checking protocol. If the server does not
+// implement it, the connectivity state of the connection is used instead.
+type HealthCheck struct {
+	// Interval between health checks. Defaults to 30 seconds.
+	Interval time.Duration
+	// Timeout of a single health check. Defaults to 5 seconds.
+	Timeout time.Duration
+	// FailureThreshold is the number of consecutive failed checks after which the connection
+	// is evicted. Defaults to 2.
+	FailureThreshold int
+	// Service name sent in health check requests. Empty string checks the server as a whole.
+	Service string
+	// OnStateChange is called when the state of a connection changes or when the connection
+	// is evicted. Calls are made sequentially from a background goroutine.
+	OnStateChange func(ConnStateEvent)
+}
+
+func (hc HealthCheck) withDefaults() HealthCheck {
+	if hc.Interval <= 0 {
+		hc.Interval = defaultHealthInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = defaultHealthTimeout
+	}
+	if hc.FailureThreshold <= 0 {
+		hc.FailureThreshold = defaultHealthThreshold
+	}
+	return hc
+}
+
+// ConnStateEvent describes a state transition of a per-language connection.
+type ConnStateEvent struct {
+	// Language served by the connection.
+	Language string
+	// Target is the address of the connection. For connections with multiple replicas,
+	// the address of the primary replica is reported for evictions.
+	Target string
+	// From and To are the previous and the new connectivity state of the connection.
+	// Evicted connections are reported with the Shutdown state.
+	From, To connectivity.State
+	// Evicted is set if the connection was removed from the client after failing health checks.
+	Evicted bool
+	// Err is the last health check error, if any.
+	Err error
+}
+
+// replicaHealth is the health checking state of a replica.
+type replicaHealth struct {
+	// state is the last observed connectivity state
+	state connectivity.State
+	// noService is set if the server does not implement the health checking protocol
+	noService bool
+}
+
+// healthLoop periodically checks all the connections of the client until it is closed.
+func (c *multipleDriverClient) healthLoop(hc HealthCheck) {
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		for _, connD := range c.readyDrivers() {
+			c.checkHealth(hc, connD)
+		}
+	}
+}
+
+// readyDrivers returns all the established connections of the client.
+func (c *multipleDriverClient) readyDrivers() []*connDriver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]*connDriver, 0, len(c.drivers))
+	for _, connD := range c.drivers {
+		if connD.established() {
+			out = append(out, connD)
+		}
+	}
+	return out
+}
+
+// checkHealth checks all the replicas of a connection. Failed replicas are ejected, and the whole
+// connection is evicted if all the replicas failed too many checks in a row.
+func (c *multipleDriverClient) checkHealth(hc HealthCheck, connD *connDriver) {
+	var lastErr error
+	healthy := false
+	for _, r := range connD.replicas {
+		h := &r.health
+		err := c.probe(hc, r)
+
+		state := r.conn.GetState()
+		if state != h.state {
+			c.notify(hc, ConnStateEvent{
+				Language: connD.lang, Target: r.conn.Target(),
+				From: h.state, To: state, Err: err,
+			})
+			h.state = state
+		}
+		if err == nil {
+			healthy = true
+			continue
+		}
+		lastErr = err
+		if len(connD.replicas) > 1 {
+			r.eject(c.lb.EjectionTime)
+		}
+	}
+	if healthy {
+		connD.failures = 0
+		return
+	}
+	connD.failures++
+	if connD.failures < hc.FailureThreshold {
+		return
+	}
+	c.evict(connD, &c.stats.EvictedUnhealthy)
+	primary := connD.replicas[0]
+	c.notify(hc, ConnStateEvent{
+		Language: connD.lang, Target: primary.conn.Target(),
+		From: primary.health.state, To: connectivity.Shutdown, Evicted: true, Err: lastErr,
+	})
+}
+
+// probe runs a single health check of the replica.
+func (c *multipleDriverClient) probe(hc HealthCheck, r *replica) error {
+	if !r.health.noService {
+		ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+		defer cancel()
+		resp, err := healthpb.NewHealthClient(r.conn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: hc.Service,
+		}, c.opts.callOptions()...)
+		if err == nil {
+			if resp.Status != healthpb.HealthCheckResponse_SERVING {
+				return status.Errorf(codes.Unavailable, "health check: %v", resp.Status)
+			}
+			return nil
+		} else if !isServiceNotSupported(err) {
+			return err
+		}
+		r.health.noService = true
+	}
+	switch state := r.conn.GetState(); state {
+	case connectivity.Connecting, connectivity.TransientFailure, connectivity.Shutdown:
+		return status.Errorf(codes.Unavailable, "connection state: %v", state)
+	}
+	return nil
+}
+
+func (c *multipleDriverClient) notify(hc HealthCheck, ev ConnStateEvent) {
+	if hc.OnStateChange != nil {
+		hc.OnStateChange(ev)
+	}
+}
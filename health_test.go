@@ -0,0 +1,142 @@
+package bblfsh
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newHealthMockServer is the same as newMockServer, but also serves the gRPC health checking protocol.
+func newHealthMockServer(t testing.TB, s *mockServer) (string, *health.Server, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	protocol2.RegisterDriverServer(srv, s)
+	protocol2.RegisterDriverHostServer(srv, s)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+
+	return lis.Addr().String(), hs, srv.Stop
+}
+
+// waitEvent waits for a connection state event matching the condition.
+func waitEvent(t testing.TB, events <-chan ConnStateEvent, fnc func(ev ConnStateEvent) bool) ConnStateEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if fnc(ev) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for the connection state event")
+			return ConnStateEvent{}
+		}
+	}
+}
+
+func TestHealthCheck_EvictNotServing(t *testing.T) {
+	addr, hs, stop := newHealthMockServer(t, &mockServer{})
+	defer stop()
+	getConn, dials := countingConnFunc(addr)
+
+	events := make(chan ConnStateEvent, 10)
+	cli, err := NewClientWithConnectionsContext(getConn, WithHealthCheck(HealthCheck{
+		Interval:         10 * time.Millisecond,
+		FailureThreshold: 1,
+		OnStateChange: func(ev ConnStateEvent) {
+			events <- ev
+		},
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	ev := waitEvent(t, events, func(ev ConnStateEvent) bool { return ev.Evicted })
+	require.Equal(t, "python", ev.Language)
+	require.Equal(t, addr, ev.Target)
+	require.Equal(t, connectivity.Shutdown, ev.To)
+	require.Error(t, ev.Err)
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+
+	v, _ := dials.Load("python")
+	require.Equal(t, int32(2), atomic.LoadInt32(v.(*int32)))
+}
+
+func TestHealthCheck_ConnectivityFallback(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	getConn, dials := countingConnFunc(addr)
+
+	events := make(chan ConnStateEvent, 10)
+	cli, err := NewClientWithConnectionsContext(getConn, WithHealthCheck(HealthCheck{
+		Interval:         10 * time.Millisecond,
+		FailureThreshold: 2,
+		OnStateChange: func(ev ConnStateEvent) {
+			events <- ev
+		},
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+
+	// let the checker find out that the health service is not implemented
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event: %+v", ev)
+	default:
+	}
+
+	stop()
+	waitEvent(t, events, func(ev ConnStateEvent) bool {
+		return !ev.Evicted && ev.From == connectivity.Ready
+	})
+	waitEvent(t, events, func(ev ConnStateEvent) bool { return ev.Evicted })
+
+	// the next request should redial the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = cli.NewParseRequest().Context(ctx).Language("python").Content("foo").Do()
+	require.Error(t, err)
+
+	v, _ := dials.Load("python")
+	require.Equal(t, int32(2), atomic.LoadInt32(v.(*int32)))
+}
+
+func TestHealthCheck_EvictWaitsForInflight(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, _ := countingConnFunc(addr)
+
+	dc := newMultipleDriverClient(singleReplica(getConn), clientOptions{})
+	defer dc.Close()
+
+	connD, err := dc.getDriver(context.Background(), "python")
+	require.NoError(t, err)
+
+	dc.evict(connD, &dc.stats.EvictedUnhealthy)
+	require.NotEqual(t, connectivity.Shutdown, connD.replicas[0].conn.GetState())
+
+	dc.release(connD)
+	require.Equal(t, connectivity.Shutdown, connD.replicas[0].conn.GetState())
+}
@@ -0,0 +1,209 @@
+package bblfsh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bblfsh/sdk/v3/driver"
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+)
+
+const (
+	// infoTTL is how long versions of the server and drivers are cached by the client.
+	// They are requested again after that, so driver upgrades are noticed.
+	infoTTL = 5 * time.Minute
+
+	// infoRetryDelay is a delay before requesting the versions again after a failure.
+	infoRetryDelay = 5 * time.Second
+)
+
+// serverInfo contains versions of the server and drivers.
+type serverInfo struct {
+	version string
+	// drivers maps languages and their aliases to driver manifests
+	drivers map[string]*DriverManifestV2
+}
+
+// driver returns a manifest of the driver for a given language, or nil if it's unknown.
+func (info *serverInfo) driver(lang string) *DriverManifestV2 {
+	return info.drivers[lang]
+}
+
+// driverVersion returns the version of the driver for a given language.
+// The server version is returned if the driver is unknown.
+func (info *serverInfo) driverVersion(lang string) string {
+	if m := info.driver(lang); m != nil {
+		return m.Version
+	}
+	return info.version
+}
+
+// infoCache caches the server info returned by the load function.
+//
+// Only one request for the info is sent at a time, and it does not depend on the context
+// of the caller that triggered it. Expired info is returned while it's being refreshed.
+// If the request fails, it's not sent again for a while; the last known info is returned
+// in the meantime, if any.
+type infoCache struct {
+	load  func(ctx context.Context) (*serverInfo, error)
+	ttl   time.Duration
+	retry time.Duration
+
+	mu      sync.Mutex
+	info    *serverInfo
+	expires time.Time
+	err     error
+	retryAt time.Time
+	// loading is closed when the current request completes; nil if there is none
+	loading chan struct{}
+}
+
+func newInfoCache(load func(ctx context.Context) (*serverInfo, error)) *infoCache {
+	return &infoCache{load: load, ttl: infoTTL, retry: infoRetryDelay}
+}
+
+// get returns the cached info, requesting it if necessary.
+func (ic *infoCache) get(ctx context.Context) (*serverInfo, error) {
+	ic.mu.Lock()
+	now := time.Now()
+	if ic.info != nil && now.Before(ic.expires) {
+		info := ic.info
+		ic.mu.Unlock()
+		return info, nil
+	}
+	if ic.loading == nil && (ic.err == nil || !now.Before(ic.retryAt)) {
+		ic.loading = make(chan struct{})
+		go ic.refresh(ic.loading)
+	}
+	if ic.info != nil || ic.loading == nil {
+		// expired, or the last request failed
+		info, err := ic.info, ic.err
+		ic.mu.Unlock()
+		if info != nil {
+			return info, nil
+		}
+		return nil, err
+	}
+	loading := ic.loading
+	ic.mu.Unlock()
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if ic.info != nil {
+		return ic.info, nil
+	}
+	return nil, ic.err
+}
+
+// refresh requests the info and notifies the callers waiting for it.
+func (ic *infoCache) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultConnTimeout)
+	defer cancel()
+	info, err := ic.load(ctx)
+
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	defer close(done)
+	ic.loading = nil
+	now := time.Now()
+	if err != nil {
+		ic.err, ic.retryAt = err, now.Add(ic.retry)
+		return
+	}
+	ic.info, ic.expires, ic.err = info, now.Add(ic.ttl), nil
+}
+
+// languageInfo keeps server info per language. Multi-endpoint clients may send each language
+// to a different server, so the info is requested through the connection serving the language.
+// Other clients use a single cache for all languages.
+type languageInfo struct {
+	load    func(ctx context.Context, lang string) (*serverInfo, error)
+	perLang bool
+
+	mu     sync.Mutex
+	byLang map[string]*infoCache
+}
+
+// cache returns the info cache for a given language.
+func (li *languageInfo) cache(lang string) *infoCache {
+	if !li.perLang {
+		lang = ""
+	}
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	ic, ok := li.byLang[lang]
+	if !ok {
+		ic = newInfoCache(func(ctx context.Context) (*serverInfo, error) {
+			return li.load(ctx, lang)
+		})
+		li.byLang[lang] = ic
+	}
+	return ic
+}
+
+// newLanguageInfo creates server info caches for the client.
+func (c *Client) newLanguageInfo() *languageInfo {
+	li := &languageInfo{byLang: make(map[string]*infoCache)}
+	if dc, ok := c.driver2.(*multipleDriverClient); ok {
+		li.perLang = true
+		li.load = func(ctx context.Context, lang string) (*serverInfo, error) {
+			return c.loadLanguageInfo(ctx, dc, lang)
+		}
+	} else {
+		li.load = func(ctx context.Context, _ string) (*serverInfo, error) {
+			return loadServerInfo(ctx, c.driver)
+		}
+	}
+	return li
+}
+
+// serverInfo returns versions of the server and drivers that serve a given language,
+// see infoCache.
+func (c *Client) serverInfo(ctx context.Context, lang string) (*serverInfo, error) {
+	return c.info.cache(lang).get(ctx)
+}
+
+// loadLanguageInfo requests versions of the server and drivers through the connection that
+// serves a given language. The connection is dialed if necessary.
+func (c *Client) loadLanguageInfo(ctx context.Context, dc *multipleDriverClient, lang string) (*serverInfo, error) {
+	connD, err := dc.getDriver(ctx, lang)
+	if err != nil {
+		return nil, err
+	}
+	defer dc.release(connD)
+	conn := connD.pick(dc.lb).conn
+	host := withHostCallOptions(protocol2.NewDriverHostClient(conn), c.callOpts)
+	return loadServerInfo(ctx, protocol2.DriverFromClient(protocol2.NewDriverClient(conn), host))
+}
+
+// loadServerInfo requests versions of the server and drivers. Servers that do not implement
+// the requests are allowed, in which case versions are left empty.
+func loadServerInfo(ctx context.Context, drv driver.Driver) (*serverInfo, error) {
+	info := &serverInfo{drivers: make(map[string]*DriverManifestV2)}
+	vers, err := drv.Version(ctx)
+	if err == nil {
+		info.version = vers.Version
+	} else if !isServiceNotSupported(err) {
+		return nil, err
+	}
+	list, err := drv.Languages(ctx)
+	if err != nil && !isServiceNotSupported(err) {
+		return nil, err
+	}
+	for i := range list {
+		m := &list[i]
+		info.drivers[m.Language] = m
+		for _, a := range m.Aliases {
+			if _, ok := info.drivers[a]; !ok {
+				info.drivers[a] = m
+			}
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,183 @@
+package bblfsh
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+// loadResult is a version or an error returned by versionLoader.
+type loadResult struct {
+	vers string
+	err  error
+}
+
+// versionLoader returns a load function for infoCache that returns the stored result,
+// and counts the calls.
+func versionLoader(res *atomic.Value, calls *int32) func(ctx context.Context) (*serverInfo, error) {
+	return func(ctx context.Context) (*serverInfo, error) {
+		atomic.AddInt32(calls, 1)
+		r := res.Load().(loadResult)
+		if r.err != nil {
+			return nil, r.err
+		}
+		return &serverInfo{version: r.vers}, nil
+	}
+}
+
+func TestInfoCache(t *testing.T) {
+	var (
+		vers  atomic.Value
+		calls int32
+	)
+	vers.Store(loadResult{vers: "v1"})
+	ic := newInfoCache(versionLoader(&vers, &calls))
+	ctx := context.Background()
+
+	info, err := ic.get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1", info.version)
+	_, err = ic.get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// expired info is returned while it's refreshed
+	vers.Store(loadResult{vers: "v2"})
+	ic.mu.Lock()
+	ic.expires = time.Now()
+	ic.mu.Unlock()
+	info, err = ic.get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1", info.version)
+	waitInfo(t, ic, "v2")
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestInfoCache_Error(t *testing.T) {
+	var (
+		vers  atomic.Value
+		calls int32
+	)
+	errFailed := errors.New("failed")
+	vers.Store(loadResult{err: errFailed})
+	ic := newInfoCache(versionLoader(&vers, &calls))
+	ctx := context.Background()
+
+	_, err := ic.get(ctx)
+	require.Equal(t, errFailed, err)
+
+	// the request is not sent again until the retry delay passes
+	vers.Store(loadResult{vers: "v1"})
+	_, err = ic.get(ctx)
+	require.Equal(t, errFailed, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	ic.mu.Lock()
+	ic.retryAt = time.Now()
+	ic.mu.Unlock()
+	info, err := ic.get(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "v1", info.version)
+
+	// the last known info is returned if the refresh fails
+	vers.Store(loadResult{err: errFailed})
+	ic.mu.Lock()
+	ic.expires = time.Now()
+	ic.mu.Unlock()
+	for atomic.LoadInt32(&calls) != 3 {
+		info, err = ic.get(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "v1", info.version)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestInfoCache_Concurrent(t *testing.T) {
+	var calls int32
+	block := make(chan struct{})
+	ic := newInfoCache(func(ctx context.Context) (*serverInfo, error) {
+		atomic.AddInt32(&calls, 1)
+		<-block
+		return &serverInfo{version: "v1"}, nil
+	})
+
+	// the caller that triggered the request gives up, but the others still get the info
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ic.get(ctx)
+	require.Equal(t, context.Canceled, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ic.get(context.Background())
+			errs <- err
+		}()
+	}
+	close(block)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestClient_ServerInfoPerLanguage(t *testing.T) {
+	pyAddr, stop := newMockServer(t, &mockServer{
+		version:   "python-driver",
+		languages: []*protocol2.Manifest{{Language: "python", Version: &protocol2.Version{Version: "v2"}}},
+	})
+	defer stop()
+	defaultAddr, stop2 := newMockServer(t, &mockServer{
+		version: "bblfshd",
+		languages: []*protocol2.Manifest{
+			{Language: "python", Version: &protocol2.Version{Version: "v1"}},
+			{Language: "go", Version: &protocol2.Version{Version: "v1"}},
+		},
+	})
+	defer stop2()
+
+	cli, err := NewClientContext(context.Background(), "python="+pyAddr+","+defaultAddr)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// the info is requested only from the endpoint that parsed the file
+	res, err := cli.NewParseRequest().Language("python").Content("import foo").Result()
+	require.NoError(t, err)
+	require.Equal(t, "python-driver", res.ServerVersion)
+	require.NotNil(t, res.Driver)
+	require.Equal(t, "v2", res.Driver.Version)
+	require.Equal(t, int64(1), cli.ConnStats().Dialed)
+
+	res, err = cli.NewParseRequest().Language("go").Content("package main").Result()
+	require.NoError(t, err)
+	require.Equal(t, "bblfshd", res.ServerVersion)
+	require.NotNil(t, res.Driver)
+	require.Equal(t, "v1", res.Driver.Version)
+	require.Equal(t, int64(2), cli.ConnStats().Dialed)
+}
+
+// waitInfo waits until the cache returns a given version.
+func waitInfo(t *testing.T, ic *infoCache, vers string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := ic.get(context.Background())
+		if err == nil && info.version == vers {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected version %q, got %v, %v", vers, info, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,105 @@
+package bblfsh
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// LogLevel is a severity of log messages.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Field is a key-value pair attached to a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives structured log messages from the client. See WithLogger.
+//
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+// NewStdLogger creates a Logger that writes messages to a standard library logger in logfmt format:
+//
+//	level=warn msg="retrying parse request" language=python attempt=2
+//
+// If l is nil, the standard logger is used.
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l: l}
+}
+
+type stdLogger struct {
+	// l is nil for the standard logger
+	l *log.Logger
+}
+
+// Log implements Logger.
+func (l stdLogger) Log(level LogLevel, msg string, fields ...Field) {
+	var buf strings.Builder
+	buf.WriteString("level=")
+	buf.WriteString(level.String())
+	buf.WriteString(" msg=")
+	buf.WriteString(logfmtValue(msg))
+	for _, f := range fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(fmt.Sprint(f.Value)))
+	}
+	if l.l == nil {
+		log.Output(2, buf.String())
+	} else {
+		l.l.Output(2, buf.String())
+	}
+}
+
+// logfmtValue quotes the value if necessary.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// clientLogger filters messages by level. A nil logger discards all messages.
+type clientLogger struct {
+	l   Logger
+	min LogLevel
+}
+
+func (l *clientLogger) log(level LogLevel, msg string, fields ...Field) {
+	if l == nil || level < l.min {
+		return
+	}
+	l.l.Log(level, msg, fields...)
+}
+
+// enabled checks if messages of a given level are logged.
+func (l *clientLogger) enabled(level LogLevel) bool {
+	return l != nil && level >= l.min
+}
@@ -0,0 +1,165 @@
+package bblfsh
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger records log messages.
+type testLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, fields ...Field) {
+	e := logEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+// find returns the entries with a given message.
+func (l *testLogger) find(msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []logEntry
+	for _, e := range l.entries {
+		if e.msg == msg {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewStdLogger(log.New(buf, "", 0))
+	l.Log(LevelWarn, "retrying parse request", Field{"attempt", 2}, Field{"error", "driver is down"}, Field{"filename", ""})
+	require.Equal(t, `level=warn msg="retrying parse request" attempt=2 error="driver is down" filename=""`+"\n", buf.String())
+}
+
+func TestStdLogger_Default(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+	NewStdLogger(nil).Log(LevelInfo, "dialed", Field{"language", "python"})
+	require.Equal(t, "level=info msg=dialed language=python\n", buf.String())
+}
+
+func TestLogger_Nil(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	// a nil logger disables logging, including the one set before
+	l := &testLogger{}
+	cli, err := NewClientWithOptionsContext(context.Background(), "python="+addr,
+		WithLogger(l, LevelDebug), WithLogger(nil, LevelDebug))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").Do()
+	require.NoError(t, err)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.Empty(t, l.entries)
+}
+
+func TestLogger_Parse(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			return nil, status.Error(codes.Unavailable, "driver is down")
+		},
+	})
+	defer stop()
+
+	l := &testLogger{}
+	cli, err := NewClientWithOptionsContext(context.Background(), addr,
+		WithLogger(l, LevelWarn), WithRetryPolicy(testRetryPolicy()))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Filename("foo.py").Content("x").UAST()
+	require.Error(t, err)
+
+	retries := l.find("retrying parse request")
+	require.Len(t, retries, testRetryPolicy().MaxAttempts-1)
+	require.Equal(t, LevelWarn, retries[0].level)
+	require.Equal(t, 2, retries[0].fields["attempt"])
+
+	failed := l.find("parse request failed")
+	require.Len(t, failed, 1)
+	require.Equal(t, LevelError, failed[0].level)
+	require.Equal(t, "python", failed[0].fields["language"])
+	require.Equal(t, "foo.py", failed[0].fields["filename"])
+	require.Equal(t, addr, failed[0].fields["endpoint"])
+	require.Equal(t, codes.Unavailable, failed[0].fields["code"])
+
+	// debug messages are discarded
+	_, _, err = cli.NewParseRequest().Language("python").Content("x").UAST()
+	require.Error(t, err)
+	for _, e := range l.entries {
+		require.True(t, e.level >= LevelWarn, "%v", e)
+	}
+}
+
+func TestLogger_Dial(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	l := &testLogger{}
+	cli := newReplicaClient(t, "python="+addr+",go="+addr, WithLogger(l, LevelDebug))
+	defer cli.Close()
+
+	_, _, err := cli.NewParseRequest().Language("python").Content("x").UAST()
+	require.NoError(t, err)
+
+	require.Len(t, l.find("dialing"), 1)
+	dialed := l.find("dialed")
+	require.Len(t, dialed, 1)
+	require.Equal(t, LevelInfo, dialed[0].level)
+	require.Equal(t, "python", dialed[0].fields["language"])
+	require.Equal(t, addr, dialed[0].fields["endpoint"])
+}
+
+func TestLogger_FallbackV1(t *testing.T) {
+	// server without the v2 host service
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	protocol2.RegisterDriverServer(srv, &mockServer{})
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	l := &testLogger{}
+	cli, err := NewClientWithOptionsContext(context.Background(), lis.Addr().String(), WithLogger(l, LevelInfo))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	entries := l.find("server does not support protocol v2, using v1 for version and supported languages")
+	require.Len(t, entries, 1)
+	require.Equal(t, lis.Addr().String(), entries[0].fields["endpoint"])
+}
@@ -0,0 +1,188 @@
+package bblfsh
+
+import (
+	"sync"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorClass is a class of parse errors reported to Metrics.
+type ErrorClass string
+
+const (
+	// NoError is reported for successful requests.
+	NoError ErrorClass = ""
+	// SyntaxErrorClass is reported when the driver cannot parse the file, see ErrSyntax.
+	SyntaxErrorClass ErrorClass = "syntax"
+	// DriverFailureClass is reported when the driver is malfunctioning, see ErrDriverFailure.
+	DriverFailureClass ErrorClass = "driver_failure"
+	// RequestErrorClass is reported when the server rejects the request, for example
+	// because the language or the mode is not supported.
+	RequestErrorClass ErrorClass = "request"
+	// TransportErrorClass is reported for network errors, timeouts and other RPC failures.
+	TransportErrorClass ErrorClass = "transport"
+)
+
+// ParseMetric describes a single parse request.
+type ParseMetric struct {
+	// Language of the request. It's the language reported by the server, if any.
+	Language string
+	// Duration of the request, including retries.
+	Duration time.Duration
+	// ContentSize is the size of the source code in bytes.
+	ContentSize int
+	// UASTSize is the size of the encoded UAST in bytes.
+	UASTSize int
+	// Error is the class of the error, if the request failed.
+	Error ErrorClass
+	// Cached is set if the response was returned from the parse cache.
+	Cached bool
+}
+
+// Metrics collects client-side metrics of the parse traffic. See WithMetrics.
+//
+// Implementations must be safe for concurrent use and should not block. MemoryMetrics keeps
+// the metrics in memory, and the github.com/bblfsh/go-client/v4/prometheus module exports
+// them to Prometheus.
+type Metrics interface {
+	// ObserveParse records a parse request.
+	ObserveParse(m ParseMetric)
+	// ObserveCache records a parse cache lookup. It's called only if the cache is enabled.
+	ObserveCache(lang string, hit bool)
+	// SetOpenConns sets the number of open per-language connections.
+	// It's called only by clients with multiple endpoints.
+	SetOpenConns(n int)
+}
+
+// errorClass classifies the result of a parse RPC.
+func errorClass(resp *protocol2.ParseResponse, err error) ErrorClass {
+	if err == nil {
+		if resp != nil && len(resp.Errors) != 0 {
+			return SyntaxErrorClass
+		}
+		return NoError
+	}
+	switch status.Code(err) {
+	case codes.Internal:
+		return DriverFailureClass
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.NotFound, codes.Unimplemented:
+		return RequestErrorClass
+	}
+	return TransportErrorClass
+}
+
+// LanguageMetrics are metrics of a single language collected by MemoryMetrics.
+type LanguageMetrics struct {
+	// Requests is the number of parse requests.
+	Requests int64
+	// Errors is the number of failed requests by the error class.
+	Errors map[ErrorClass]int64
+	// TotalDuration and MaxDuration are the total and the maximal duration of requests.
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	// ContentBytes and UASTBytes are the total sizes of the source code and the UAST.
+	ContentBytes int64
+	UASTBytes    int64
+	// CacheHits and CacheMisses are the number of parse cache lookups.
+	CacheHits   int64
+	CacheMisses int64
+}
+
+// MemoryMetrics is a Metrics implementation that keeps the metrics in memory.
+// It is mostly useful for tests. The zero value is ready to use.
+type MemoryMetrics struct {
+	mu    sync.Mutex
+	langs map[string]*LanguageMetrics
+	open  int
+}
+
+// NewMemoryMetrics creates an empty in-memory metrics collector.
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{langs: make(map[string]*LanguageMetrics)}
+}
+
+func (m *MemoryMetrics) language(lang string) *LanguageMetrics {
+	if m.langs == nil {
+		m.langs = make(map[string]*LanguageMetrics)
+	}
+	lm := m.langs[lang]
+	if lm == nil {
+		lm = &LanguageMetrics{Errors: make(map[ErrorClass]int64)}
+		m.langs[lang] = lm
+	}
+	return lm
+}
+
+// ObserveParse implements Metrics.
+func (m *MemoryMetrics) ObserveParse(pm ParseMetric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lm := m.language(pm.Language)
+	lm.Requests++
+	if pm.Error != NoError {
+		lm.Errors[pm.Error]++
+	}
+	lm.TotalDuration += pm.Duration
+	if pm.Duration > lm.MaxDuration {
+		lm.MaxDuration = pm.Duration
+	}
+	lm.ContentBytes += int64(pm.ContentSize)
+	lm.UASTBytes += int64(pm.UASTSize)
+}
+
+// ObserveCache implements Metrics.
+func (m *MemoryMetrics) ObserveCache(lang string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lm := m.language(lang)
+	if hit {
+		lm.CacheHits++
+	} else {
+		lm.CacheMisses++
+	}
+}
+
+// SetOpenConns implements Metrics.
+func (m *MemoryMetrics) SetOpenConns(n int) {
+	m.mu.Lock()
+	m.open = n
+	m.mu.Unlock()
+}
+
+// Language returns a copy of the metrics for a given language.
+func (m *MemoryMetrics) Language(lang string) LanguageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lm, ok := m.langs[lang]
+	if !ok {
+		return LanguageMetrics{}
+	}
+	out := *lm
+	out.Errors = make(map[ErrorClass]int64, len(lm.Errors))
+	for k, v := range lm.Errors {
+		out.Errors[k] = v
+	}
+	return out
+}
+
+// Languages returns all the languages with recorded metrics.
+func (m *MemoryMetrics) Languages() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, 0, len(m.langs))
+	for lang := range m.langs {
+		out = append(out, lang)
+	}
+	return out
+}
+
+// OpenConns returns the last reported number of open per-language connections.
+func (m *MemoryMetrics) OpenConns() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.open
+}
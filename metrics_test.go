@@ -0,0 +1,78 @@
+package bblfsh
+
+import (
+	"context"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			switch req.Content {
+			case "bad":
+				return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language, Errors: []*protocol2.ParseError{
+					{Text: "unexpected token"},
+				}}, nil
+			case "crash":
+				return nil, status.Error(codes.Internal, "driver crashed")
+			case "down":
+				return nil, status.Error(codes.Unavailable, "driver is down")
+			}
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+		languages: []*protocol2.Manifest{
+			{Language: "python", Version: &protocol2.Version{Version: "v1.2.3"}},
+		},
+	})
+	defer stop()
+
+	m := NewMemoryMetrics()
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithMetrics(m), WithCache(NewMemoryCache(1<<20)))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	for _, content := range []string{"import foo", "import foo", "bad", "crash", "down"} {
+		_, _, _ = cli.NewParseRequest().Language("python").Content(content).UAST()
+	}
+
+	lm := m.Language("python")
+	require.Equal(t, int64(5), lm.Requests)
+	require.Equal(t, map[ErrorClass]int64{
+		SyntaxErrorClass:    1,
+		DriverFailureClass:  1,
+		TransportErrorClass: 1,
+	}, lm.Errors)
+	require.Equal(t, int64(1), lm.CacheHits)
+	require.Equal(t, int64(4), lm.CacheMisses)
+	require.Equal(t, int64(len("import foo")*2+len("bad")+len("crash")+len("down")), lm.ContentBytes)
+	// successful requests, including the cached one, and the syntax error
+	require.Equal(t, int64(3*len(mockUAST)), lm.UASTBytes)
+	require.True(t, lm.MaxDuration > 0)
+	require.True(t, lm.TotalDuration >= lm.MaxDuration)
+	require.Equal(t, []string{"python"}, m.Languages())
+}
+
+func TestMetrics_OpenConns(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	// the zero value is usable
+	m := &MemoryMetrics{}
+	cli := newReplicaClient(t, "python="+addr+",go="+addr, WithMetrics(m))
+
+	_, _, err := cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.NoError(t, err)
+	require.Equal(t, 1, m.OpenConns())
+
+	_, _, err = cli.NewParseRequest().Language("go").Content("package foo").UAST()
+	require.NoError(t, err)
+	require.Equal(t, 2, m.OpenConns())
+
+	require.NoError(t, cli.Close())
+	require.Equal(t, 0, m.OpenConns())
+}
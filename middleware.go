@@ -0,0 +1,78 @@
+package bblfsh
+
+import (
+	"context"
+	"errors"
+)
+
+// errTranscodedContent is returned if parse middleware changes the content of a request
+// that was transcoded from a different encoding.
+var errTranscodedContent = errors.New("middleware cannot change the content of a transcoded request")
+
+// ParseCall is a parse request passed through middleware. Middleware may change the fields
+// before calling the next handler, for example to redact the content.
+//
+// The content cannot be changed if the request is transcoded (see ParseRequest.Encoding),
+// since positions in the UAST could not be mapped back to the original content.
+// Such requests fail without being sent.
+type ParseCall struct {
+	Language string
+	Filename string
+	Mode     Mode
+	// Content is the source code to parse. It's already transcoded to UTF-8 if the encoding
+	// was set for the request.
+	Content string
+}
+
+// ParseHandler sends a parse request and returns the result with the decoded UAST.
+// Errors are returned as is; ParseRequest wraps them in *ParseError after the middleware.
+type ParseHandler func(ctx context.Context, call ParseCall) (*ParseResult, error)
+
+// VersionHandler requests the version of the server.
+type VersionHandler func(ctx context.Context) (*VersionResponse, error)
+
+// LanguagesHandler requests the languages supported by the server.
+type LanguagesHandler func(ctx context.Context) ([]DriverManifestV2, error)
+
+// Middleware wraps client operations to add behavior around them, such as auditing
+// or request rewriting. Each function receives the next handler in the chain and returns
+// a handler that should call it. Any of the fields may be nil.
+//
+// Parse middleware is called by ParseRequest.Do, ParseRequest.UAST, ParseRequest.Result and
+// batch requests, after the language detection and filters. If the middleware returns a result
+// without calling the next handler, Do encodes it as a parse response.
+type Middleware struct {
+	Parse     func(next ParseHandler) ParseHandler
+	Version   func(next VersionHandler) VersionHandler
+	Languages func(next LanguagesHandler) LanguagesHandler
+}
+
+// parseChain wraps the handler with the parse middleware. The first middleware is the outermost.
+func parseChain(mws []Middleware, h ParseHandler) ParseHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i].Parse != nil {
+			h = mws[i].Parse(h)
+		}
+	}
+	return h
+}
+
+// versionChain is the same as parseChain, but for version requests.
+func versionChain(mws []Middleware, h VersionHandler) VersionHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i].Version != nil {
+			h = mws[i].Version(h)
+		}
+	}
+	return h
+}
+
+// languagesChain is the same as parseChain, but for supported languages requests.
+func languagesChain(mws []Middleware, h LanguagesHandler) LanguagesHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i].Languages != nil {
+			h = mws[i].Languages(h)
+		}
+	}
+	return h
+}
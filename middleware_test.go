@@ -0,0 +1,193 @@
+package bblfsh
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/bblfsh/sdk/v3/uast/nodes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			mu.Lock()
+			sent = append(sent, req.Content)
+			mu.Unlock()
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	})
+	defer stop()
+
+	var calls []string
+	redact := Middleware{
+		Parse: func(next ParseHandler) ParseHandler {
+			return func(ctx context.Context, call ParseCall) (*ParseResult, error) {
+				calls = append(calls, "redact")
+				call.Content = strings.Replace(call.Content, "secret", "******", -1)
+				return next(ctx, call)
+			}
+		},
+	}
+	audit := Middleware{
+		Parse: func(next ParseHandler) ParseHandler {
+			return func(ctx context.Context, call ParseCall) (*ParseResult, error) {
+				calls = append(calls, "audit:"+call.Filename)
+				res, err := next(ctx, call)
+				if err == nil && res.Node == nil {
+					return nil, errors.New("no UAST")
+				}
+				return res, err
+			}
+		},
+		Version: func(next VersionHandler) VersionHandler {
+			return func(ctx context.Context) (*VersionResponse, error) {
+				calls = append(calls, "version")
+				return next(ctx)
+			}
+		},
+		Languages: func(next LanguagesHandler) LanguagesHandler {
+			return func(ctx context.Context) ([]DriverManifestV2, error) {
+				calls = append(calls, "languages")
+				return nil, errors.New("denied")
+			}
+		},
+	}
+
+	for _, c := range []struct {
+		name string
+		cli  func(opts ...ClientOption) *Client
+	}{
+		{"single", func(opts ...ClientOption) *Client {
+			cli, err := NewClientWithOptionsContext(context.Background(), addr, opts...)
+			require.NoError(t, err)
+			return cli
+		}},
+		{"multiple", func(opts ...ClientOption) *Client {
+			return newReplicaClient(t, "python="+addr, opts...)
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			calls, sent = nil, nil
+			cli := c.cli(WithMiddleware(audit, redact))
+			defer cli.Close()
+
+			ast, lang, err := cli.NewParseRequest().Language("python").Filename("foo.py").
+				Content("password = 'secret'").UAST()
+			require.NoError(t, err)
+			require.NotNil(t, ast)
+			require.Equal(t, "python", lang)
+			require.Equal(t, []string{"password = '******'"}, sent)
+
+			// Do returns the raw response, but it's wrapped by middleware as well
+			resp, err := cli.NewParseRequest().Language("python").Filename("bar.py").Content("secret").Do()
+			require.NoError(t, err)
+			require.Equal(t, mockUAST, resp.Uast)
+			require.Equal(t, []string{"password = '******'", "******"}, sent)
+
+			_, err = cli.NewVersionRequest().Do()
+			require.NoError(t, err)
+			_, err = cli.NewSupportedLanguagesRequest().Do()
+			require.EqualError(t, err, "denied")
+
+			require.Equal(t, []string{
+				"audit:foo.py", "redact", "audit:bar.py", "redact", "version", "languages",
+			}, calls)
+		})
+	}
+}
+
+func TestMiddleware_Transcoded(t *testing.T) {
+	var sent []string
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			sent = append(sent, req.Content)
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	})
+	defer stop()
+
+	rewrite := Middleware{
+		Parse: func(next ParseHandler) ParseHandler {
+			return func(ctx context.Context, call ParseCall) (*ParseResult, error) {
+				call.Content = strings.Replace(call.Content, "secret", "******", -1)
+				return next(ctx, call)
+			}
+		},
+	}
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithMiddleware(rewrite))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// the content is not changed, so the request is allowed
+	_, _, err = cli.NewParseRequest().Language("python").Content(bomUTF16LE + utf16le("x = 1")).
+		DetectEncoding().UAST()
+	require.NoError(t, err)
+	require.Equal(t, []string{"x = 1"}, sent)
+
+	_, _, err = cli.NewParseRequest().Language("python").Content(bomUTF16LE + utf16le("x = 'secret'")).
+		DetectEncoding().UAST()
+	require.Equal(t, errTranscodedContent, parseErrCause(t, err))
+	require.Len(t, sent, 1)
+
+	_, err = cli.NewParseRequest().Language("python").Content(bomUTF16LE + utf16le("x = 'secret'")).
+		DetectEncoding().Do()
+	require.Equal(t, errTranscodedContent, parseErrCause(t, err))
+	require.Len(t, sent, 1)
+
+	// invalid UTF-8 is replaced, so positions are mapped as well
+	_, _, err = cli.NewParseRequest().Language("python").Content("x = 'secret\xff'").
+		Encoding(UTF8).UAST()
+	require.Equal(t, errTranscodedContent, parseErrCause(t, err))
+	require.Len(t, sent, 1)
+
+	// requests without transcoding can be changed
+	_, _, err = cli.NewParseRequest().Language("python").Content("x = 'secret'").UAST()
+	require.NoError(t, err)
+	require.Equal(t, []string{"x = 1", "x = '******'"}, sent)
+}
+
+func TestMiddleware_DoResult(t *testing.T) {
+	var sent int
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			sent++
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	})
+	defer stop()
+
+	node, err := (&protocol2.ParseResponse{Uast: mockUAST}).Nodes()
+	require.NoError(t, err)
+
+	// the middleware returns the result without sending the request
+	cached := Middleware{
+		Parse: func(next ParseHandler) ParseHandler {
+			return func(ctx context.Context, call ParseCall) (*ParseResult, error) {
+				return &ParseResult{Node: node, Language: call.Language, Errors: SyntaxErrors{{Message: "oops"}}}, nil
+			}
+		},
+	}
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithMiddleware(cached))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	resp, err := cli.NewParseRequest().Language("python").Content("x").Do()
+	require.NoError(t, err)
+	require.Equal(t, 0, sent)
+	require.Equal(t, "python", resp.Language)
+	require.Len(t, resp.Errors, 1)
+	require.Equal(t, "oops", resp.Errors[0].Text)
+
+	got, err := (&protocol2.ParseResponse{Uast: resp.Uast}).Nodes()
+	require.NoError(t, err)
+	require.True(t, nodes.Equal(node, got))
+}
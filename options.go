@@ -0,0 +1,263 @@
+package bblfsh
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ClientOption is an option that configures the bblfsh client.
+//
+// Client options are accepted by NewClientWithOptionsContext and other client constructors.
+// gRPC dial options can be passed together with them using WithDialOptions.
+type ClientOption interface {
+	applyClient(*clientOptions)
+}
+
+// clientOptions is a set of client-wide settings.
+type clientOptions struct {
+	// retry is a default retry policy for parse requests; nil disables retries
+	retry *RetryPolicy
+	// cache is a parse cache; nil disables caching
+	cache Cache
+	// tls enables TLS for connections dialed by the client; nil means insecure connections
+	tls *TLSConfig
+	// creds are attached to every RPC
+	creds []credentials.PerRPCCredentials
+	// languages are known to be served by a multi-endpoint client
+	languages []string
+	// health enables health checks of per-language connections; nil disables them
+	health *HealthCheck
+	// limits of per-language connections; zero value means no limits
+	limits ConnLimits
+	// balancing configures load balancing between replicas
+	balancing LoadBalancing
+	// detect enables client-side language detection
+	detect bool
+	// maxSize is the maximal size of parsed files; zero means no limit
+	maxSize int64
+	// filters are checked before sending parse requests
+	filters []Filter
+	// timeouts of individual RPCs
+	timeouts Timeouts
+	// metrics collector; nil disables metrics
+	metrics Metrics
+	// tracer creates spans for client calls; nil disables tracing
+	tracer Tracer
+	// logger receives log messages; nil disables logging
+	logger *clientLogger
+	// middleware wraps client operations; the first one is the outermost
+	middleware []Middleware
+	// rateLimits of parse requests
+	rateLimits RateLimits
+	// dialOpts are used when dialing connections to the endpoints
+	dialOpts []grpc.DialOption
+}
+
+type clientOption struct {
+	fnc func(*clientOptions)
+}
+
+func (o clientOption) applyClient(opts *clientOptions) {
+	o.fnc(opts)
+}
+
+// callOptions returns gRPC call options that should be added to each RPC.
+func (opts *clientOptions) callOptions() []grpc.CallOption {
+	var out []grpc.CallOption
+	for _, c := range opts.creds {
+		out = append(out, grpc.PerRPCCredentials(c))
+	}
+	return out
+}
+
+func newClientOption(fnc func(*clientOptions)) ClientOption {
+	return clientOption{fnc: fnc}
+}
+
+// newClientOptions applies all client options to a new client configuration.
+func newClientOptions(options []ClientOption) clientOptions {
+	var opts clientOptions
+	for _, o := range options {
+		o.applyClient(&opts)
+	}
+	return opts
+}
+
+// WithRetryPolicy sets a default retry policy for all parse requests of the client.
+// It can be overridden for a single request with ParseRequest.RetryPolicy.
+//
+// Zero fields of the policy are set to the defaults, see RetryPolicy.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	p = p.withDefaults()
+	return newClientOption(func(opts *clientOptions) {
+		opts.retry = &p
+	})
+}
+
+// WithCache enables caching of parse responses using a given cache backend.
+// See NewMemoryCache and NewDiskCache.
+func WithCache(c Cache) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.cache = c
+	})
+}
+
+// WithTLS enables TLS for all connections dialed by NewClientContext, including the per-language
+// connections for the language mapping and template endpoints. Endpoints may override it,
+// see Endpoint.TLS.
+//
+// It has no effect on clients created from existing connections.
+func WithTLS(conf TLSConfig) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.tls = &conf
+	})
+}
+
+// WithPerRPCCredentials attaches credentials to every RPC made by the client,
+// including requests sent over per-language connections.
+func WithPerRPCCredentials(creds credentials.PerRPCCredentials) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.creds = append(opts.creds, creds)
+	})
+}
+
+// WithTokenSource authenticates every RPC made by the client with a token from src.
+// Tokens are sent in the "authorization" header.
+//
+// Tokens are sent even over insecure connections; use WithTLS to protect them.
+func WithTokenSource(src TokenSource) ClientOption {
+	return WithPerRPCCredentials(tokenCredentials{src: src})
+}
+
+// WithAPIKey sends an API key in a given header with every RPC made by the client.
+func WithAPIKey(header, key string) ClientOption {
+	return WithPerRPCCredentials(headerCredentials{header: header, value: key})
+}
+
+// WithKnownLanguages sets languages that are served by a multi-endpoint client created with
+// NewClientWithConnectionsContext. Version and supported languages requests are sent to the
+// endpoints of these languages, in addition to the ones that were already used for parsing.
+// Connections for these languages are dialed by such requests if they are not established yet.
+//
+// An empty language refers to the default endpoint. For clients created from an endpoint string
+// or configuration, the mapped languages are added automatically, as well as the default
+// endpoint if the configuration has one.
+func WithKnownLanguages(langs ...string) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.languages = append(opts.languages, langs...)
+	})
+}
+
+// WithHealthCheck enables periodic health checks of per-language connections of the client.
+// Unhealthy connections are closed and redialed on the next request for the language.
+//
+// The option has no effect for clients with a single connection.
+func WithHealthCheck(hc HealthCheck) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.health = &hc
+	})
+}
+
+// WithConnLimits limits the number of per-language connections the client keeps open.
+//
+// The option has no effect for clients with a single connection.
+func WithConnLimits(l ConnLimits) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.limits = l
+	})
+}
+
+// WithLoadBalancing configures how requests are balanced between replicas of an endpoint.
+//
+// The option has no effect for clients with a single connection.
+func WithLoadBalancing(lb LoadBalancing) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.balancing = lb
+	})
+}
+
+// WithLanguageDetection enables client-side language detection for parse requests without
+// a language, see DetectLanguage. Otherwise the language is detected by the server.
+//
+// Detection is always enabled for clients with multiple endpoints, since requests are routed
+// to the endpoints by language.
+func WithLanguageDetection() ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.detect = true
+	})
+}
+
+// WithMaxFileSize sets the maximal size of files in bytes for all parse requests of the client.
+// Larger files are rejected with FileTooLargeError before sending. See ParseRequest.MaxSize.
+func WithMaxFileSize(n int64) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.maxSize = n
+	})
+}
+
+// WithFilters sets filters that are checked before sending each parse request of the client.
+// Files rejected by a filter are skipped instead of being parsed. See DefaultFilters.
+func WithFilters(filters ...Filter) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.filters = append(opts.filters, filters...)
+	})
+}
+
+// WithTimeouts sets deadlines of individual RPCs sent by the client. See ParseRequest.Timeout.
+func WithTimeouts(t Timeouts) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.timeouts = t
+	})
+}
+
+// WithMetrics enables collection of client-side metrics. See MemoryMetrics.
+func WithMetrics(m Metrics) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.metrics = m
+	})
+}
+
+// WithTracer enables tracing of client calls. Each parse, version and supported languages
+// request produces a span, and the trace context is sent to the server as gRPC metadata.
+// See MemoryTracer.
+func WithTracer(t Tracer) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.tracer = t
+	})
+}
+
+// WithLogger sets a logger for the client. Messages with a level lower than min are discarded.
+// A nil logger disables logging. See NewStdLogger.
+func WithLogger(l Logger, min LogLevel) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		if l == nil {
+			opts.logger = nil
+			return
+		}
+		opts.logger = &clientLogger{l: l, min: min}
+	})
+}
+
+// WithMiddleware adds middleware that wraps parse, version and supported languages requests
+// of the client. The first middleware is the outermost one.
+func WithMiddleware(mws ...Middleware) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.middleware = append(opts.middleware, mws...)
+	})
+}
+
+// WithRateLimits sets client-side rate limits and concurrency caps for parse requests.
+func WithRateLimits(l RateLimits) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.rateLimits = l
+	})
+}
+
+// WithDialOptions adds gRPC dial options used for connections dialed by the client.
+//
+// It has no effect on clients created from existing connections.
+func WithDialOptions(dopts ...grpc.DialOption) ClientOption {
+	return newClientOption(func(opts *clientOptions) {
+		opts.dialOpts = append(opts.dialOpts, dopts...)
+	})
+}
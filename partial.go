@@ -0,0 +1,105 @@
+package bblfsh
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/bblfsh/sdk/v3/uast"
+)
+
+// SyntaxError is a single error reported by the driver for a file.
+type SyntaxError struct {
+	// Message is the error message as reported by the driver.
+	Message string
+	// Position of the error, if it can be determined from the message.
+	Position *uast.Position
+}
+
+func (e SyntaxError) Error() string {
+	return e.Message
+}
+
+// SyntaxErrors is a list of errors reported by the driver for a file.
+//
+// It is wrapped by ParseError instead of ErrSyntax for requests in partial mode,
+// see ParseRequest.Partial.
+type SyntaxErrors []SyntaxError
+
+func (e SyntaxErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Message)
+	}
+	return "syntax error: " + strings.Join(msgs, "; ")
+}
+
+// errorPositionPatterns extract the line and the column (if any) from driver error messages.
+//
+// Patterns are anchored to the formats used by drivers, so numbers in other parts
+// of the message, such as times or host:port pairs, are not mistaken for positions.
+var errorPositionPatterns = []*regexp.Regexp{
+	// "line 3, column 5", "(<unknown>, line 3)", "Line 3 col 5"
+	regexp.MustCompile(`(?i)\bline:? (\d+)(?:,? col(?:umn)?:? (\d+))?\b`),
+	// "3:5: unexpected token", "file.go:3:5: expected ';'"
+	regexp.MustCompile(`^(?:[^\s:]+:)?(\d+):(\d+):`),
+	// "unexpected token (3:5)", "[3,5]"
+	regexp.MustCompile(`[(\[](\d+)(?:,\s*|:)(\d+)[)\]]\s*$`),
+}
+
+// newSyntaxErrors converts driver errors to syntax errors, extracting positions from messages.
+func newSyntaxErrors(content string, errs []*protocol2.ParseError) SyntaxErrors {
+	out := make(SyntaxErrors, 0, len(errs))
+	for _, e := range errs {
+		out = append(out, SyntaxError{Message: e.Text, Position: errorPosition(content, e.Text)})
+	}
+	return out
+}
+
+// errorPosition extracts a position from the error message. The offset is computed from
+// the line and the column using the file content.
+func errorPosition(content, msg string) *uast.Position {
+	for _, re := range errorPositionPatterns {
+		m := re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		line, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil || line == 0 {
+			continue
+		}
+		pos := &uast.Position{Line: uint32(line)}
+		if m[2] != "" {
+			if col, err := strconv.ParseUint(m[2], 10, 32); err == nil {
+				pos.Col = uint32(col)
+			}
+		}
+		if pos.Col != 0 {
+			if start, end, ok := lineBounds(content, pos.Line); ok && pos.Col-1 <= end-start {
+				// the column may point right after the last character of the line
+				pos.Offset = start + pos.Col - 1
+			}
+		}
+		return pos
+	}
+	return nil
+}
+
+// lineBounds returns byte offsets of the start and the end of a given line (1-based),
+// excluding the line terminator.
+func lineBounds(content string, line uint32) (start, end uint32, _ bool) {
+	off := 0
+	for i := uint32(1); i < line; i++ {
+		j := strings.IndexByte(content[off:], '\n')
+		if j < 0 {
+			return 0, 0, false
+		}
+		off += j + 1
+	}
+	n := strings.IndexByte(content[off:], '\n')
+	if n < 0 {
+		n = len(content) - off
+	}
+	return uint32(off), uint32(off + n), true
+}
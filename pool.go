@@ -0,0 +1,125 @@
+package bblfsh
+
+import "time"
+
+// minIdleCheckInterval is the minimal interval between checks for idle connections.
+const minIdleCheckInterval = 100 * time.Millisecond
+
+// ConnLimits configures limits of per-language connections of a multi-endpoint client.
+type ConnLimits struct {
+	// IdleTimeout closes connections that were not used for a given duration.
+	// Zero disables idle eviction.
+	IdleTimeout time.Duration
+	// MaxOpen is the maximal number of open connections. When the limit is reached,
+	// the least recently used connection is closed before dialing a new one.
+	// Connections that are still being dialed cannot be evicted, thus the limit
+	// can be exceeded temporarily. Zero means no limit.
+	MaxOpen int
+}
+
+// ConnStats contains statistics of per-language connections of a multi-endpoint client.
+type ConnStats struct {
+	// Open is the number of currently open connections.
+	Open int
+	// Dialed is the total number of successfully dialed connections.
+	Dialed int64
+	// EvictedIdle is the number of connections closed because of the idle timeout.
+	EvictedIdle int64
+	// EvictedLimit is the number of connections closed because of the open connections limit.
+	EvictedLimit int64
+	// EvictedUnhealthy is the number of connections closed after failing health checks.
+	EvictedUnhealthy int64
+}
+
+// Evicted returns the total number of evicted connections.
+func (s ConnStats) Evicted() int64 {
+	return s.EvictedIdle + s.EvictedLimit + s.EvictedUnhealthy
+}
+
+// ConnStats returns statistics of per-language connections.
+// It returns zero values for clients with a single connection.
+func (c *Client) ConnStats() ConnStats {
+	dc, ok := c.closer.(*multipleDriverClient)
+	if !ok {
+		return ConnStats{}
+	}
+	return dc.connStats()
+}
+
+// connStats returns the current connection statistics.
+func (c *multipleDriverClient) connStats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	for _, connD := range c.drivers {
+		if connD.established() {
+			st.Open++
+		}
+	}
+	return st
+}
+
+// evictLRULocked evicts the least recently used connections to make room for a new one.
+// It returns connections that must be closed after releasing the lock.
+func (c *multipleDriverClient) evictLRULocked() []*connDriver {
+	max := c.opts.limits.MaxOpen
+	if max <= 0 {
+		return nil
+	}
+	var out []*connDriver
+	for len(c.drivers) >= max {
+		var lru *connDriver
+		for _, connD := range c.drivers {
+			if !connD.established() {
+				continue
+			}
+			if lru == nil || connD.lastUsed.Before(lru.lastUsed) {
+				lru = connD
+			}
+		}
+		if lru == nil {
+			// all the connections are still dialing
+			break
+		}
+		if c.evictLocked(lru, &c.stats.EvictedLimit) {
+			out = append(out, lru)
+		}
+	}
+	return out
+}
+
+// idleLoop periodically evicts connections that were not used for a given duration.
+func (c *multipleDriverClient) idleLoop(timeout time.Duration) {
+	interval := timeout / 2
+	if interval < minIdleCheckInterval {
+		interval = minIdleCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.evictIdle(now.Add(-timeout))
+		}
+	}
+}
+
+// evictIdle evicts all the unused connections that were last used before a given time.
+func (c *multipleDriverClient) evictIdle(before time.Time) {
+	var evicted []*connDriver
+	c.mu.Lock()
+	for _, connD := range c.drivers {
+		if connD.inflight > 0 || !connD.established() || !connD.lastUsed.Before(before) {
+			continue
+		}
+		if c.evictLocked(connD, &c.stats.EvictedIdle) {
+			evicted = append(evicted, connD)
+		}
+	}
+	c.mu.Unlock()
+	for _, connD := range evicted {
+		connD.close()
+	}
+}
@@ -0,0 +1,92 @@
+package bblfsh
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimits_MaxOpen(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, dials := countingConnFunc(addr)
+
+	cli, err := NewClientWithConnectionsContext(getConn, WithConnLimits(ConnLimits{MaxOpen: 2}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	parse := func(lang string) {
+		_, err := cli.NewParseRequest().Language(lang).Content("foo").Do()
+		require.NoError(t, err)
+	}
+	parse("python")
+	parse("go")
+	parse("python")
+	// go is the least recently used connection
+	parse("java")
+
+	st := cli.ConnStats()
+	require.Equal(t, 2, st.Open)
+	require.Equal(t, int64(3), st.Dialed)
+	require.Equal(t, int64(1), st.EvictedLimit)
+	require.Equal(t, int64(1), st.Evicted())
+
+	parse("python")
+	parse("go")
+	for lang, exp := range map[string]int32{"python": 1, "go": 2, "java": 1} {
+		v, _ := dials.Load(lang)
+		require.Equal(t, exp, atomic.LoadInt32(v.(*int32)), lang)
+	}
+	st = cli.ConnStats()
+	require.Equal(t, 2, st.Open)
+	require.Equal(t, int64(2), st.EvictedLimit)
+}
+
+func TestConnLimits_IdleTimeout(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, _ := countingConnFunc(addr)
+
+	cli, err := NewClientWithConnectionsContext(getConn, WithConnLimits(ConnLimits{IdleTimeout: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	require.Equal(t, 1, cli.ConnStats().Open)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cli.ConnStats().Open != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := cli.ConnStats()
+	require.Equal(t, 0, st.Open)
+	require.Equal(t, int64(1), st.EvictedIdle)
+
+	_, err = cli.NewParseRequest().Language("python").Content("foo").Do()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cli.ConnStats().Dialed)
+}
+
+func TestConnLimits_IdleSkipsInflight(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+	getConn, _ := countingConnFunc(addr)
+
+	dc := newMultipleDriverClient(singleReplica(getConn), clientOptions{})
+	defer dc.Close()
+
+	connD, err := dc.getDriver(context.Background(), "python")
+	require.NoError(t, err)
+
+	dc.evictIdle(time.Now().Add(time.Hour))
+	require.Equal(t, 1, dc.connStats().Open)
+
+	dc.release(connD)
+	dc.evictIdle(time.Now().Add(time.Hour))
+	require.Equal(t, 0, dc.connStats().Open)
+	require.Equal(t, int64(1), dc.connStats().EvictedIdle)
+}
@@ -0,0 +1,13 @@
+module github.com/bblfsh/go-client/v4/prometheus
+
+go 1.12
+
+require (
+	github.com/bblfsh/go-client/v4 v4.0.1-0.20261018020216-dccf664d66cc
+	github.com/prometheus/client_golang v1.1.0
+	github.com/stretchr/testify v1.3.0
+)
+
+// the required version is the first one with the Metrics interface; the replace directive
+// only builds the adapter against the client in the same checkout, and is ignored by dependents
+replace github.com/bblfsh/go-client/v4 => ../
@@ -0,0 +1,129 @@
+// Package prometheus exposes client-side metrics of the Babelfish client to Prometheus.
+//
+//	m := prometheus.NewMetrics("bblfsh")
+//	prom.MustRegister(m)
+//	cli, err := bblfsh.NewClientWithOptionsContext(ctx, addr, bblfsh.WithMetrics(m))
+//
+// The package is a separate module, so the client does not depend on the Prometheus library.
+package prometheus
+
+import (
+	"github.com/bblfsh/go-client/v4"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements bblfsh.Metrics using Prometheus collectors.
+// It must be registered in a Prometheus registry to be exported.
+type Metrics struct {
+	requests    *prom.CounterVec
+	errors      *prom.CounterVec
+	duration    *prom.HistogramVec
+	contentSize *prom.HistogramVec
+	uastSize    *prom.HistogramVec
+	cache       *prom.CounterVec
+	openConns   prom.Gauge
+}
+
+var _ bblfsh.Metrics = (*Metrics)(nil)
+
+// NewMetrics creates Prometheus collectors for the client metrics with a given namespace.
+func NewMetrics(namespace string) *Metrics {
+	const subsystem = "client"
+	sizeBuckets := prom.ExponentialBuckets(256, 4, 8) // 256B to 4MB
+	return &Metrics{
+		requests: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "parse_requests_total",
+			Help: "Number of parse requests.",
+		}, []string{"language", "cached"}),
+		errors: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "parse_errors_total",
+			Help: "Number of failed parse requests by the error class.",
+		}, []string{"language", "class"}),
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name:    "parse_duration_seconds",
+			Help:    "Duration of parse requests, including retries.",
+			Buckets: prom.ExponentialBuckets(0.005, 2, 12), // 5ms to 10s
+		}, []string{"language"}),
+		contentSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name:    "parse_content_bytes",
+			Help:    "Size of the source code sent for parsing.",
+			Buckets: sizeBuckets,
+		}, []string{"language"}),
+		uastSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name:    "parse_uast_bytes",
+			Help:    "Size of the encoded UAST received from the server.",
+			Buckets: sizeBuckets,
+		}, []string{"language"}),
+		cache: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "parse_cache_lookups_total",
+			Help: "Number of parse cache lookups.",
+		}, []string{"language", "result"}),
+		openConns: prom.NewGauge(prom.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem,
+			Name: "open_connections",
+			Help: "Number of open per-language connections.",
+		}),
+	}
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	return []prom.Collector{
+		m.requests, m.errors, m.duration, m.contentSize, m.uastSize, m.cache, m.openConns,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// ObserveParse implements bblfsh.Metrics.
+func (m *Metrics) ObserveParse(pm bblfsh.ParseMetric) {
+	cached := "false"
+	if pm.Cached {
+		cached = "true"
+	}
+	m.requests.WithLabelValues(pm.Language, cached).Inc()
+	if pm.Error != bblfsh.NoError {
+		m.errors.WithLabelValues(pm.Language, string(pm.Error)).Inc()
+	}
+	if pm.Cached {
+		// cached responses would skew the latency and the payload sizes
+		return
+	}
+	m.duration.WithLabelValues(pm.Language).Observe(pm.Duration.Seconds())
+	m.contentSize.WithLabelValues(pm.Language).Observe(float64(pm.ContentSize))
+	if pm.UASTSize != 0 {
+		m.uastSize.WithLabelValues(pm.Language).Observe(float64(pm.UASTSize))
+	}
+}
+
+// ObserveCache implements bblfsh.Metrics.
+func (m *Metrics) ObserveCache(lang string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.WithLabelValues(lang, result).Inc()
+}
+
+// SetOpenConns implements bblfsh.Metrics.
+func (m *Metrics) SetOpenConns(n int) {
+	m.openConns.Set(float64(n))
+}
@@ -0,0 +1,61 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bblfsh/go-client/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("bblfsh")
+	reg := prom.NewPedanticRegistry()
+	require.NoError(t, reg.Register(m))
+
+	m.ObserveParse(bblfsh.ParseMetric{Language: "python", Duration: time.Millisecond, ContentSize: 10, UASTSize: 100})
+	m.ObserveParse(bblfsh.ParseMetric{Language: "python", Cached: true, ContentSize: 10, UASTSize: 100})
+	m.ObserveParse(bblfsh.ParseMetric{Language: "go", Duration: time.Second, ContentSize: 20, Error: bblfsh.TransportErrorClass})
+	m.ObserveCache("python", true)
+	m.ObserveCache("python", false)
+	m.SetOpenConns(2)
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("python", "true")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.requests.WithLabelValues("python", "false")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("go", "transport")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.cache.WithLabelValues("python", "hit")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.cache.WithLabelValues("python", "miss")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.openConns))
+
+	err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP bblfsh_client_parse_content_bytes Size of the source code sent for parsing.
+# TYPE bblfsh_client_parse_content_bytes histogram
+bblfsh_client_parse_content_bytes_bucket{language="go",le="256"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="1024"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="4096"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="16384"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="65536"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="262144"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="1.048576e+06"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="4.194304e+06"} 1
+bblfsh_client_parse_content_bytes_bucket{language="go",le="+Inf"} 1
+bblfsh_client_parse_content_bytes_sum{language="go"} 20
+bblfsh_client_parse_content_bytes_count{language="go"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="256"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="1024"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="4096"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="16384"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="65536"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="262144"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="1.048576e+06"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="4.194304e+06"} 1
+bblfsh_client_parse_content_bytes_bucket{language="python",le="+Inf"} 1
+bblfsh_client_parse_content_bytes_sum{language="python"} 10
+bblfsh_client_parse_content_bytes_count{language="python"} 1
+`), "bblfsh_client_parse_content_bytes")
+	require.NoError(t, err)
+}
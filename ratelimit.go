@@ -0,0 +1,206 @@
+package bblfsh
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit limits the rate and the concurrency of parse requests.
+type RateLimit struct {
+	// Rate is the number of requests per second. Zero means no rate limit.
+	Rate float64
+	// Burst is the number of requests that can be sent at once after a period of inactivity.
+	// Defaults to one.
+	Burst int
+	// MaxInFlight is the maximal number of concurrent requests. Zero means no limit.
+	MaxInFlight int
+}
+
+// RateLimits configures client-side limits of parse requests. Requests exceeding the limits
+// wait until they can be sent, or until the request context is cancelled.
+//
+// Each retry attempt is limited separately, like a new request.
+type RateLimits struct {
+	// Global limits apply to all parse requests of the client.
+	Global RateLimit
+	// Languages are limits of individual languages, in addition to the global ones.
+	// They don't apply to requests without a language.
+	Languages map[string]RateLimit
+}
+
+// limiter enforces a single RateLimit.
+type limiter struct {
+	bucket *tokenBucket
+	// sem limits the number of requests in flight; nil means no limit
+	sem chan struct{}
+}
+
+func newLimiter(l RateLimit) *limiter {
+	if l.Rate <= 0 && l.MaxInFlight <= 0 {
+		return nil
+	}
+	lim := &limiter{}
+	if l.Rate > 0 {
+		lim.bucket = newTokenBucket(l.Rate, l.Burst)
+	}
+	if l.MaxInFlight > 0 {
+		lim.sem = make(chan struct{}, l.MaxInFlight)
+	}
+	return lim
+}
+
+// acquireSlot waits for a free in-flight slot.
+func (l *limiter) acquireSlot(ctx context.Context) error {
+	if l == nil || l.sem == nil {
+		return nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseSlot frees the slot acquired by acquireSlot.
+func (l *limiter) releaseSlot() {
+	if l != nil && l.sem != nil {
+		<-l.sem
+	}
+}
+
+// wait waits until the request can be sent according to the rate limit.
+func (l *limiter) wait(ctx context.Context) error {
+	if l == nil || l.bucket == nil {
+		return nil
+	}
+	return l.bucket.wait(ctx)
+}
+
+// cancelWait returns the token taken by a successful wait, if the request is not sent.
+func (l *limiter) cancelWait() {
+	if l != nil && l.bucket != nil {
+		l.bucket.cancel()
+	}
+}
+
+// tokenBucket is a token bucket rate limiter.
+type tokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns the time the caller must wait before using it.
+// The number of tokens may become negative, which queues the callers.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a token reserved by a caller that gave up waiting.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// wait takes a token, waiting for it if necessary.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	d := b.reserve(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// clientLimits enforces RateLimits of a client.
+type clientLimits struct {
+	global *limiter
+	langs  map[string]*limiter
+}
+
+func newClientLimits(l RateLimits) *clientLimits {
+	cl := &clientLimits{global: newLimiter(l.Global)}
+	for lang, ll := range l.Languages {
+		if lim := newLimiter(ll); lim != nil {
+			if cl.langs == nil {
+				cl.langs = make(map[string]*limiter)
+			}
+			cl.langs[lang] = lim
+		}
+	}
+	if cl.global == nil && cl.langs == nil {
+		return nil
+	}
+	return cl
+}
+
+// acquire waits until a request for the language can be sent. The returned function must be
+// called when the request completes.
+func (cl *clientLimits) acquire(ctx context.Context, lang string) (func(), error) {
+	if cl == nil {
+		return func() {}, nil
+	}
+	ll := cl.langs[lang]
+	// the limits of the language are checked before taking the global slot, so requests waiting
+	// for them don't hold global slots and block other languages
+	if err := ll.acquireSlot(ctx); err != nil {
+		return nil, err
+	}
+	if err := ll.wait(ctx); err != nil {
+		ll.releaseSlot()
+		return nil, err
+	}
+	if err := cl.global.acquireSlot(ctx); err != nil {
+		ll.cancelWait()
+		ll.releaseSlot()
+		return nil, err
+	}
+	// the global token is taken only with the slot held; otherwise queued requests would keep
+	// tokens that come due while they wait, and send at once when slots are freed
+	if err := cl.global.wait(ctx); err != nil {
+		cl.global.releaseSlot()
+		ll.cancelWait()
+		ll.releaseSlot()
+		return nil, err
+	}
+	return func() {
+		cl.global.releaseSlot()
+		ll.releaseSlot()
+	}, nil
+}
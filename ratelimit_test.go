@@ -0,0 +1,293 @@
+package bblfsh
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(4, 2)
+	now := b.last
+	require.Equal(t, time.Duration(0), b.reserve(now))
+	require.Equal(t, time.Duration(0), b.reserve(now))
+	require.Equal(t, 250*time.Millisecond, b.reserve(now))
+	require.Equal(t, 500*time.Millisecond, b.reserve(now))
+
+	// callers that are already waiting are served first
+	now = now.Add(125 * time.Millisecond)
+	require.Equal(t, 625*time.Millisecond, b.reserve(now))
+
+	// tokens don't accumulate over the burst
+	now = now.Add(time.Hour)
+	require.Equal(t, time.Duration(0), b.reserve(now))
+	require.Equal(t, time.Duration(0), b.reserve(now))
+	require.Equal(t, 250*time.Millisecond, b.reserve(now))
+
+	// a cancelled reservation returns the token
+	b.cancel()
+	require.Equal(t, 250*time.Millisecond, b.reserve(now))
+}
+
+func TestTokenBucket_Context(t *testing.T) {
+	b := newTokenBucket(0.001, 1)
+	require.NoError(t, b.wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, b.wait(ctx))
+}
+
+// waitInFlight waits until the number of requests in flight reaches n.
+func waitInFlight(t *testing.T, cur *int32, n int32) {
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(cur) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d requests in flight, got %d", n, atomic.LoadInt32(cur))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingMockServer counts parse requests in flight and blocks them until release is closed.
+func blockingMockServer(cur, max *int32, release <-chan struct{}) *mockServer {
+	return &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			n := atomic.AddInt32(cur, 1)
+			defer atomic.AddInt32(cur, -1)
+			for {
+				m := atomic.LoadInt32(max)
+				if n <= m || atomic.CompareAndSwapInt32(max, m, n) {
+					break
+				}
+			}
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	}
+}
+
+func TestRateLimits_MaxInFlight(t *testing.T) {
+	var cur, max int32
+	release := make(chan struct{})
+	addr, stop := newMockServer(t, blockingMockServer(&cur, &max, release))
+	defer stop()
+
+	cli := newReplicaClient(t, "python="+addr+",go="+addr, WithRateLimits(RateLimits{
+		Global:    RateLimit{MaxInFlight: 3},
+		Languages: map[string]RateLimit{"python": {MaxInFlight: 1}},
+	}))
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for _, lang := range []string{"python", "python", "python", "go", "go", "go"} {
+		wg.Add(1)
+		go func(lang string) {
+			defer wg.Done()
+			_, _, err := cli.NewParseRequest().Language(lang).Content("x").UAST()
+			errs <- err
+		}(lang)
+	}
+	// one python request and two go requests fit into the global limit
+	waitInFlight(t, &cur, 3)
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int32(3), atomic.LoadInt32(&cur))
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&max))
+}
+
+func TestRateLimits_Context(t *testing.T) {
+	var cur, max int32
+	release := make(chan struct{})
+	addr, stop := newMockServer(t, blockingMockServer(&cur, &max, release))
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithRateLimits(RateLimits{
+		Global: RateLimit{MaxInFlight: 1},
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := cli.NewParseRequest().Language("python").Content("x").UAST()
+		done <- err
+	}()
+	waitInFlight(t, &cur, 1)
+
+	// the second request waits for the first one until the context expires
+	rctx, rcancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer rcancel()
+	_, err = cli.NewParseRequest().Context(rctx).Language("python").Content("x").Result()
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, codes.DeadlineExceeded, perr.Code)
+	require.Equal(t, ContextTimeout, perr.Timeout)
+
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, int32(1), atomic.LoadInt32(&max))
+}
+
+func TestRateLimits_Rate(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithRateLimits(RateLimits{
+		Languages: map[string]RateLimit{"python": {Rate: 20}},
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, _, err = cli.NewParseRequest().Language("python").Content("x").UAST()
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) >= 90*time.Millisecond, "%v", time.Since(start))
+
+	// other languages are not limited
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		_, _, err = cli.NewParseRequest().Language("go").Content("x").UAST()
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) < 90*time.Millisecond, "%v", time.Since(start))
+}
+
+func TestRateLimits_LanguageRateGlobalCap(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{})
+	defer stop()
+
+	cli := newReplicaClient(t, "python="+addr+",go="+addr, WithRateLimits(RateLimits{
+		Global:    RateLimit{MaxInFlight: 1},
+		Languages: map[string]RateLimit{"python": {Rate: 0.001}},
+	}))
+	defer cli.Close()
+
+	_, _, err := cli.NewParseRequest().Language("python").Content("x").UAST()
+	require.NoError(t, err)
+
+	// the next python request waits for the rate limit
+	pctx, pcancel := context.WithCancel(context.Background())
+	defer pcancel()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := cli.NewParseRequest().Context(pctx).Language("python").Content("x").UAST()
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// it must not hold the global slot in the meantime
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, _, err = cli.NewParseRequest().Context(ctx).Language("go").Content("x").UAST()
+	require.NoError(t, err)
+
+	pcancel()
+	require.Equal(t, codes.Canceled, errorCode(<-done))
+}
+
+func TestClientLimits_CancelTokens(t *testing.T) {
+	cl := newClientLimits(RateLimits{
+		Global:    RateLimit{Rate: 0.001},
+		Languages: map[string]RateLimit{"python": {Rate: 0.001}},
+	})
+	release, err := cl.acquire(context.Background(), "go")
+	require.NoError(t, err)
+	release()
+
+	// the language token is returned if the global limit is not satisfied in time
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cl.acquire(ctx, "python")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, time.Duration(0), cl.langs["python"].bucket.reserve(time.Now()))
+
+	// the language token is returned and no global token is taken if there is no free global slot
+	cl = newClientLimits(RateLimits{
+		Global:    RateLimit{Rate: 0.001, Burst: 2, MaxInFlight: 1},
+		Languages: map[string]RateLimit{"python": {Rate: 0.001}},
+	})
+	release, err = cl.acquire(context.Background(), "go")
+	require.NoError(t, err)
+	defer release()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	_, err = cl.acquire(ctx2, "python")
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, time.Duration(0), cl.global.bucket.reserve(time.Now()))
+	require.Equal(t, time.Duration(0), cl.langs["python"].bucket.reserve(time.Now()))
+}
+
+func TestClientLimits_QueuedRate(t *testing.T) {
+	cl := newClientLimits(RateLimits{
+		Global: RateLimit{Rate: 20, Burst: 1, MaxInFlight: 1},
+	})
+	release, err := cl.acquire(context.Background(), "python")
+	require.NoError(t, err)
+
+	const n = 4
+	var (
+		mu    sync.Mutex
+		times []time.Time
+		wg    sync.WaitGroup
+	)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := cl.acquire(context.Background(), "python")
+			if err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			release()
+		}()
+	}
+	// queued requests wait for the slot long enough to accumulate tokens
+	time.Sleep(300 * time.Millisecond)
+	release()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// the rate still applies once the slot is freed
+	require.Len(t, times, n)
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	for i := 1; i < n; i++ {
+		d := times[i].Sub(times[i-1])
+		require.True(t, d >= 40*time.Millisecond, "request %d sent %v after the previous one", i, d)
+	}
+}
@@ -1,10 +1,14 @@
 package bblfsh
 
 import (
+	"bytes"
 	"context"
 	"errors"
-	"io/ioutil"
+	"io"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/bblfsh/sdk/v3/driver"
@@ -12,16 +16,11 @@ import (
 	"github.com/bblfsh/sdk/v3/driver/manifest"
 	protocol2 "github.com/bblfsh/sdk/v3/protocol"
 	"github.com/bblfsh/sdk/v3/uast/nodes"
+	"github.com/bblfsh/sdk/v3/uast/nodes/nodesproto"
 	protocol1 "gopkg.in/bblfsh/sdk.v1/protocol"
-)
-
-var (
-	// ErrDriverFailure is returned when the driver is malfunctioning.
-	ErrDriverFailure = derrors.ErrDriverFailure
 
-	// ErrSyntax is returned when driver cannot parse the source file.
-	// Can be omitted for native driver implementations.
-	ErrSyntax = derrors.ErrSyntax
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
 )
 
 func errorStrings(str []string) error {
@@ -56,7 +55,28 @@ type ParseRequest struct {
 	content string
 	options driver.ParseOptions
 	client  *Client
-	err     error
+	retry   *RetryPolicy
+	detect  bool
+	partial bool
+	maxSize int64
+	// encoding is the requested source encoding; srcEnc and offsets are set after transcoding
+	encoding Encoding
+	srcEnc   Encoding
+	offsets  *offsetMap
+	// path of the file, if it was read from a file system
+	path    string
+	filters []Filter
+	timeout time.Duration
+	// timedOut is set by the last RPC attempt
+	timedOut TimeoutSource
+	// rpcErr is the error returned by the last parse RPC, if any
+	rpcErr error
+	// raw is set for requests sent by Do; resp is the last response received for them
+	raw  bool
+	resp *protocol2.ParseResponse
+	// peer is set by the last RPC attempt, if tracing is enabled
+	peer *peer.Peer
+	err  error
 }
 
 // Language sets the language of the given source file to parse. if missing
@@ -66,20 +86,200 @@ func (r *ParseRequest) Language(language string) *ParseRequest {
 	return r
 }
 
+// DetectLanguage enables client-side language detection for this request, see WithLanguageDetection.
+func (r *ParseRequest) DetectLanguage() *ParseRequest {
+	r.detect = true
+	return r
+}
+
+// detectLanguage sets the language of the request if it's empty and the detection is enabled.
+func (r *ParseRequest) detectLanguage() {
+	if r.options.Language != "" || !(r.detect || r.client.opts.detect) {
+		return
+	}
+	r.options.Language = DetectLanguage(r.options.Filename, r.content)
+}
+
 // ReadFile loads a file given a local path and sets the content and the
 // filename of the request.
+//
+// Files larger than the maximal size are not read, see MaxSize.
 func (r *ParseRequest) ReadFile(fp string) *ParseRequest {
-	data, err := ioutil.ReadFile(fp)
+	f, err := os.Open(fp)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer f.Close()
+	r.readFile(f, fp, filepath.Base(fp))
+	return r
+}
+
+// ReadFileFS is the same as ReadFile, but loads the file from a given file system.
+func (r *ParseRequest) ReadFileFS(fsys FS, name string) *ParseRequest {
+	f, err := fsys.Open(name)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer f.Close()
+	r.readFile(f, name, path.Base(name))
+	return r
+}
+
+// Reader reads the content of the parse request from rd. The content is read immediately,
+// up to the maximal size, see MaxSize.
+func (r *ParseRequest) Reader(rd io.Reader) *ParseRequest {
+	content, err := r.read(rd, -1)
 	if err != nil {
 		r.err = err
 	} else {
-		r.content = string(data)
-		r.options.Filename = filepath.Base(fp)
+		r.content = content
 	}
+	return r
+}
 
+// MaxSize sets the maximal size of the content in bytes, overriding the client default
+// set by WithMaxFileSize. Larger files are rejected with FileTooLargeError before sending
+// the request. Zero means the client default, and a negative value disables the limit.
+//
+// The size is checked when reading the content, thus it must be set before ReadFile or Reader.
+func (r *ParseRequest) MaxSize(n int64) *ParseRequest {
+	r.maxSize = n
 	return r
 }
 
+// maxContentSize returns the maximal size of the content, or zero if there is no limit.
+func (r *ParseRequest) maxContentSize() int64 {
+	max := r.maxSize
+	if max == 0 && r.client != nil {
+		max = r.client.opts.maxSize
+	}
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
+// readFile reads the content of the file and sets the path and the filename of the request.
+func (r *ParseRequest) readFile(f File, fpath, name string) {
+	r.path = fpath
+	r.options.Filename = name
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
+	content, err := r.read(f, size)
+	if err != nil {
+		r.err = err
+	} else {
+		r.content = content
+	}
+}
+
+// read reads the content up to the maximal size. The size is used to reject large files early,
+// and to preallocate the buffer; negative value means the size is unknown.
+func (r *ParseRequest) read(rd io.Reader, size int64) (string, error) {
+	max := r.maxContentSize()
+	if max > 0 && size > max {
+		return "", &FileTooLargeError{Filename: r.options.Filename, Size: size, MaxSize: max}
+	}
+	var buf strings.Builder
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+	if max > 0 {
+		// read one more byte to detect that the limit is exceeded
+		rd = io.LimitReader(rd, max+1)
+	}
+	n, err := io.Copy(&buf, rd)
+	if err != nil {
+		return "", err
+	}
+	if max > 0 && n > max {
+		return "", &FileTooLargeError{Filename: r.options.Filename, Size: n, MaxSize: max}
+	}
+	return buf.String(), nil
+}
+
+// DetectEncoding enables detection of the source encoding. The content is transcoded
+// to UTF-8 before sending and the BOM is stripped. See Encoding.
+func (r *ParseRequest) DetectEncoding() *ParseRequest {
+	r.encoding = autoEncoding
+	return r
+}
+
+// Encoding sets the encoding of the content. The content is transcoded to UTF-8 before
+// sending and the BOM is stripped.
+//
+// Positions in the UAST returned by UAST and Result are mapped back to byte offsets
+// in the original content. Positions in the response returned by Do are not mapped.
+func (r *ParseRequest) Encoding(enc Encoding) *ParseRequest {
+	r.encoding = enc
+	return r
+}
+
+// Timeout sets the timeout of each attempt of the parse RPC, overriding the client
+// timeouts set by WithTimeouts. Zero means the client default, and a negative value
+// disables the timeout.
+//
+// Unlike a context deadline, the timeout is applied to each retry separately.
+func (r *ParseRequest) Timeout(d time.Duration) *ParseRequest {
+	r.timeout = d
+	return r
+}
+
+// Filters adds filters that are checked before sending the request, in addition to the client
+// filters set by WithFilters. Files rejected by a filter are not sent to the server, see
+// ParseResult.Skipped and SkippedError.
+func (r *ParseRequest) Filters(filters ...Filter) *ParseRequest {
+	r.filters = append(r.filters, filters...)
+	return r
+}
+
+// skip checks the request with filters and returns a reason to skip it, if any.
+func (r *ParseRequest) skip() string {
+	var client []Filter
+	if r.client != nil {
+		client = r.client.opts.filters
+	}
+	if len(client) == 0 && len(r.filters) == 0 {
+		return ""
+	}
+	f := SourceFile{
+		Path:     r.path,
+		Filename: r.options.Filename,
+		Language: r.options.Language,
+		Content:  r.content,
+	}
+	if f.Path == "" {
+		f.Path = f.Filename
+	}
+	return skip(f, client, r.filters)
+}
+
+// prepare checks the request and transcodes the content before sending it.
+func (r *ParseRequest) prepare() error {
+	if r.err != nil {
+		return r.err
+	}
+	if max := r.maxContentSize(); max > 0 && int64(len(r.content)) > max {
+		return &FileTooLargeError{Filename: r.options.Filename, Size: int64(len(r.content)), MaxSize: max}
+	}
+	if r.encoding != "" && r.srcEnc == "" {
+		r.srcEnc = r.encoding
+		if r.srcEnc == autoEncoding {
+			r.srcEnc = DetectEncoding(r.content)
+		}
+		r.content, r.offsets = transcode(r.content, r.srcEnc)
+		if r.offsets.identity() {
+			r.offsets = nil
+		}
+	}
+	r.detectLanguage()
+	return nil
+}
+
 // Content sets the content of the parse request. It should be the source code
 // that wants to be parsed.
 func (r *ParseRequest) Content(content string) *ParseRequest {
@@ -99,48 +299,257 @@ func (r *ParseRequest) Mode(mode Mode) *ParseRequest {
 	return r
 }
 
+// Partial enables the partial mode for this request. If the driver reports syntax errors,
+// UAST returns the tree produced by the driver, if any, together with a ParseError that wraps
+// SyntaxErrors instead of ErrSyntax.
+func (r *ParseRequest) Partial() *ParseRequest {
+	r.partial = true
+	return r
+}
+
 // Context sets a cancellation context for this request.
 func (r *ParseRequest) Context(ctx context.Context) *ParseRequest {
 	r.ctx = ctx
 	return r
 }
 
+// RetryPolicy overrides the client retry policy for this request.
+func (r *ParseRequest) RetryPolicy(p RetryPolicy) *ParseRequest {
+	p = p.withDefaults()
+	r.retry = &p
+	return r
+}
+
 // Do performs the actual parsing by serializing the request, sending it to
 // bblfshd and waiting for the response.
 //
-// It's the caller's responsibility to interpret errors properly.
+// It's the caller's responsibility to interpret errors properly. Syntax errors are reported
+// in the response; other errors are returned as *ParseError.
+//
+// Files rejected by filters are not sent, and SkippedError is returned instead.
+// The request is passed through parse middleware, like the one sent by UAST.
 //
 // Deprecated: use UAST() instead
 func (r *ParseRequest) Do() (*protocol2.ParseResponse, error) {
-	if r.err != nil {
-		return nil, r.err
+	r.raw, r.resp = true, nil
+	res, err := r.parse()
+	if err != nil {
+		return r.resp, r.parseError(res, err)
 	}
-	return r.client.driver2.Parse(r.ctx, &protocol2.ParseRequest{
-		Content:  r.content,
-		Mode:     protocol2.Mode(r.options.Mode),
-		Language: r.options.Language,
-		Filename: r.options.Filename,
-	})
+	if res.Skipped != "" {
+		return nil, &SkippedError{Filename: res.Filename, Reason: res.Skipped}
+	}
+	if r.resp != nil {
+		return r.resp, nil
+	}
+	// middleware returned the result without sending the request
+	resp, err := res.response()
+	if err != nil {
+		return nil, r.parseError(res, err)
+	}
+	return resp, nil
+}
+
+// requestDriver is a DriverClient that sends parse requests on behalf of a specific ParseRequest.
+type requestDriver struct {
+	r *ParseRequest
+	// resp and err are the last received response and error
+	resp *protocol2.ParseResponse
+	err  error
+	// elapsed is the time spent waiting for the last response
+	elapsed time.Duration
+}
+
+// Parse implements protocol2.DriverClient.
+func (d *requestDriver) Parse(ctx context.Context, in *protocol2.ParseRequest, _ ...grpc.CallOption) (*protocol2.ParseResponse, error) {
+	start := time.Now()
+	resp, err := d.r.client.parse(ctx, d.r, in)
+	d.resp, d.err, d.elapsed = resp, err, time.Since(start)
+	return resp, err
 }
 
 // Node is a generic UAST node.
 type Node = nodes.Node
 
-// UAST send the request and returns decoded UAST and the language.
+// UAST send the request and returns decoded UAST and the language used by the server.
+// If the language was detected on the client side, the detected language is returned.
 //
-// If a file contains syntax error, the ErrSyntax is returned and the UAST may be nil or partial in this case.
+// If the file is skipped by a filter, SkippedError is returned, see IsSkipped.
 //
-// ErrDriverFailure is returned if the native driver is malfunctioning.
+// Other errors are returned as *ParseError. If a file contains syntax error, the error wraps
+// ErrSyntax and the UAST may be nil or partial in this case. In partial mode, the error wraps
+// SyntaxErrors instead, see Partial. The error wraps ErrDriverFailure if the native driver
+// is malfunctioning. The kind can be checked with ErrSyntax.Is(perr.Err).
 func (r *ParseRequest) UAST() (Node, string, error) {
-	ast, err := r.client.driver.Parse(r.ctx, r.content, &r.options)
-	return ast, r.options.Language, err
+	res, err := r.parse()
+	if err != nil {
+		err = r.parseError(res, err)
+	} else if res.Skipped != "" {
+		err = &SkippedError{Filename: res.Filename, Reason: res.Skipped}
+	}
+	return res.Node, res.Language, err
+}
+
+// ParseResult is a result of a parse request with the decoded UAST and the metadata.
+type ParseResult struct {
+	// Node is the decoded UAST. It may be set even if the request failed, see UAST.
+	Node Node
+	// Language used by the server to parse the file.
+	Language string
+	// Filename of the parsed file.
+	Filename string
+	// Mode of the UAST.
+	Mode Mode
+	// Skipped is the reason the file was skipped by a filter, see ParseRequest.Filters.
+	// The request is not sent in this case, and the Node is nil.
+	Skipped string
+	// Encoding of the original content. It is set only if the request was transcoded,
+	// see ParseRequest.Encoding.
+	Encoding Encoding
+	// Driver is the manifest of the driver that parsed the file. It is nil if the server
+	// does not report supported languages.
+	Driver *DriverManifestV2
+	// ServerVersion is the version of the server, if known.
+	ServerVersion string
+	// Errors are syntax errors reported by the driver.
+	Errors SyntaxErrors
+	// ParseTime is the time spent waiting for the server response, including retries.
+	ParseTime time.Duration
+	// DecodeTime is the time spent decoding the UAST.
+	DecodeTime time.Duration
+}
+
+// response encodes the result as a parse response.
+func (res *ParseResult) response() (*protocol2.ParseResponse, error) {
+	resp := &protocol2.ParseResponse{Language: res.Language}
+	if res.Node != nil {
+		var buf bytes.Buffer
+		if err := nodesproto.WriteTo(&buf, res.Node); err != nil {
+			return nil, err
+		}
+		resp.Uast = buf.Bytes()
+	}
+	for _, e := range res.Errors {
+		resp.Errors = append(resp.Errors, &protocol2.ParseError{Text: e.Message})
+	}
+	return resp, nil
+}
+
+// Result is the same as UAST, but returns the UAST together with the metadata of the response.
+// Skipped files are reported in the result instead of SkippedError.
+//
+// Driver manifests are cached by the client and requested again periodically. Clients with
+// multiple endpoints request them only from the endpoint that serves the language of the request.
+// Failure to get them is not reported as an error; the Driver field is left empty instead.
+func (r *ParseRequest) Result() (*ParseResult, error) {
+	res, err := r.parse()
+	if err != nil {
+		err = r.parseError(res, err)
+	}
+	if (err != nil && res.Node == nil) || res.Skipped != "" {
+		return res, err
+	}
+	// the connection is selected by the language of the request
+	if info, ierr := r.client.serverInfo(r.ctx, r.options.Language); ierr == nil {
+		res.Driver = info.driver(res.Language)
+		res.ServerVersion = info.version
+	}
+	return res, err
+}
+
+// parse sends the request and decodes the UAST. The result is never nil.
+func (r *ParseRequest) parse() (*ParseResult, error) {
+	res := &ParseResult{
+		Language: r.options.Language,
+		Filename: r.options.Filename,
+		Mode:     Mode(r.options.Mode),
+	}
+	if err := r.prepare(); err != nil {
+		return res, err
+	}
+	res.Encoding = r.srcEnc
+	res.Language = r.options.Language
+	if res.Skipped = r.skip(); res.Skipped != "" {
+		return res, nil
+	}
+	h := parseChain(r.client.opts.middleware, r.send)
+	out, err := h(r.ctx, ParseCall{
+		Language: r.options.Language,
+		Filename: r.options.Filename,
+		Mode:     Mode(r.options.Mode),
+		Content:  r.content,
+	})
+	if out == nil {
+		// middleware returned no result
+		out = res
+	}
+	return out, err
+}
+
+// parseError wraps an error returned by parse.
+func (r *ParseRequest) parseError(res *ParseResult, err error) *ParseError {
+	return newParseError(r, res.Language, r.rpcErr, err, res.Errors)
+}
+
+// send is the innermost parse handler; it sends the request and decodes the response.
+func (r *ParseRequest) send(ctx context.Context, call ParseCall) (*ParseResult, error) {
+	if r.offsets != nil && call.Content != r.content {
+		// positions would be mapped to the original content incorrectly
+		return nil, errTranscodedContent
+	}
+	r.options.Language = call.Language
+	r.options.Filename = call.Filename
+	r.options.Mode = driver.Mode(call.Mode)
+	r.content = call.Content
+	res := &ParseResult{
+		Language: r.options.Language,
+		Filename: r.options.Filename,
+		Mode:     call.Mode,
+		Encoding: r.srcEnc,
+	}
+	// the host client is not used for parsing
+	d := &requestDriver{r: r}
+	opts := r.options
+	start := time.Now()
+	ast, err := protocol2.DriverFromClient(d, nil).Parse(ctx, r.content, &opts)
+	if ast != nil && r.offsets != nil {
+		ast = r.offsets.positions(ast)
+	}
+	res.Node = ast
+	res.Language = opts.Language
+	r.rpcErr = d.err
+	r.resp = d.resp
+	if d.resp == nil {
+		return res, err
+	}
+	if r.raw {
+		// Do reports syntax errors in the response
+		err = nil
+	}
+	res.ParseTime = d.elapsed
+	res.DecodeTime = time.Since(start) - d.elapsed
+	if d.resp.Language != "" {
+		res.Language = d.resp.Language
+	}
+	if len(d.resp.Errors) != 0 {
+		res.Errors = newSyntaxErrors(r.content, d.resp.Errors)
+		if r.offsets != nil {
+			r.offsets.syntaxErrors(res.Errors)
+		}
+		if r.partial && !r.raw {
+			// drivers may return errors without a tree; decoding errors are irrelevant in this case
+			err = res.Errors
+		}
+	}
+	return res, err
 }
 
 // VersionRequest is a request to retrieve the version of the server.
 type VersionRequest struct {
-	ctx    context.Context
-	client *Client
-	err    error
+	ctx      context.Context
+	client   *Client
+	err      error
+	failures []*EndpointError
 }
 
 // Context sets a cancellation context for this request.
@@ -163,11 +572,24 @@ type VersionResponse struct {
 // bblfsd and waiting for the response.
 func (r *VersionRequest) Do() (*VersionResponse, error) {
 	if r.err != nil {
-		return nil, r.err
+		return nil, newServerError("version", r.err)
 	}
-	resp, err := r.client.driver.Version(r.ctx)
+	return versionChain(r.client.opts.middleware, r.send)(r.ctx)
+}
+
+// send is the innermost version handler.
+func (r *VersionRequest) send(ctx context.Context) (*VersionResponse, error) {
+	ctx, end := r.client.startHostRequest(ctx, SpanVersion)
+	ctx, errs := withEndpointErrors(ctx)
+	rctx, cancel := withTimeout(ctx, r.client.opts.timeouts.RPC)
+	defer cancel()
+	resp, err := r.client.driver.Version(rctx)
+	r.failures = errs.list()
+	end(err)
 	if err != nil {
-		return nil, err
+		se := newServerError("version", err)
+		se.setTimeout(timeoutSource(ctx, rctx, err))
+		return nil, se
 	}
 	return &VersionResponse{
 		Version: resp.Version,
@@ -175,11 +597,21 @@ func (r *VersionRequest) Do() (*VersionResponse, error) {
 	}, nil
 }
 
+// Failures returns errors of individual endpoints for the last call of Do.
+//
+// Clients with multiple endpoints request the version from the mapped and default endpoints and from
+// the ones that were already used for parsing (see WithKnownLanguages), and only fail
+// if all of them fail. Other clients never report failures here.
+func (r *VersionRequest) Failures() []*EndpointError {
+	return r.failures
+}
+
 // SupportedLanguagesRequest is a request to retrieve the supported languages.
 type SupportedLanguagesRequest struct {
-	ctx    context.Context
-	client *Client
-	err    error
+	ctx      context.Context
+	client   *Client
+	err      error
+	failures []*EndpointError
 }
 
 // Context sets a cancellation context for this request.
@@ -198,9 +630,9 @@ type DriverManifest = protocol1.DriverManifest
 // Deprecated: use DoV2 instead.
 func (r *SupportedLanguagesRequest) Do() ([]DriverManifest, error) {
 	if r.err != nil {
-		return nil, r.err
+		return nil, newServerError("supported languages", r.err)
 	}
-	list, err := r.client.driver.Languages(r.ctx)
+	list, err := r.languages()
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +659,36 @@ type DriverManifestV2 = manifest.Manifest
 // DoV2 performs the supported languages request and return information about available drivers.
 func (r *SupportedLanguagesRequest) DoV2() ([]DriverManifestV2, error) {
 	if r.err != nil {
-		return nil, r.err
+		return nil, newServerError("supported languages", r.err)
 	}
-	return r.client.driver.Languages(r.ctx)
+	return r.languages()
+}
+
+func (r *SupportedLanguagesRequest) languages() ([]DriverManifestV2, error) {
+	return languagesChain(r.client.opts.middleware, r.send)(r.ctx)
+}
+
+// send is the innermost supported languages handler.
+func (r *SupportedLanguagesRequest) send(ctx context.Context) ([]DriverManifestV2, error) {
+	ctx, end := r.client.startHostRequest(ctx, SpanSupportedLanguages)
+	ctx, errs := withEndpointErrors(ctx)
+	rctx, cancel := withTimeout(ctx, r.client.opts.timeouts.RPC)
+	defer cancel()
+	list, err := r.client.driver.Languages(rctx)
+	r.failures = errs.list()
+	end(err)
+	if err != nil {
+		se := newServerError("supported languages", err)
+		se.setTimeout(timeoutSource(ctx, rctx, err))
+		return nil, se
+	}
+	return list, nil
+}
+
+// Failures returns errors of individual endpoints for the last call of Do or DoV2.
+//
+// Clients with multiple endpoints request supported languages from all of them, merge the
+// results and only fail if all the endpoints fail. Other clients never report failures here.
+func (r *SupportedLanguagesRequest) Failures() []*EndpointError {
+	return r.failures
 }
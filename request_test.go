@@ -1,11 +1,17 @@
 package bblfsh
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/bblfsh/sdk/v3/uast"
 	"github.com/stretchr/testify/require"
 )
 
@@ -34,6 +40,113 @@ func TestParseRequest_ReadFileError(t *testing.T) {
 	require.Errorf(t, err, "open NO_EXISTS: no such file or directory")
 }
 
+func TestParseRequest_Reader(t *testing.T) {
+	req := &ParseRequest{}
+	req = req.Reader(strings.NewReader("a=b+c"))
+	require.NoError(t, req.err)
+	require.Equal(t, "a=b+c", req.content)
+
+	req = &ParseRequest{}
+	req = req.Filename("foo.py").MaxSize(3).Reader(strings.NewReader("a=b+c"))
+
+	require.Equal(t, &FileTooLargeError{Filename: "foo.py", Size: 4, MaxSize: 3}, req.err)
+}
+
+func TestParseRequest_ReadFileFS(t *testing.T) {
+	tmpfile := tempFile(t)
+	defer os.RemoveAll(tmpfile.Name())
+	dir, name := filepath.Split(tmpfile.Name())
+
+	req := &ParseRequest{}
+	req = req.ReadFileFS(Dir(dir), name)
+	require.NoError(t, req.err)
+	require.Equal(t, name, req.options.Filename)
+	require.Equal(t, "foo", req.content)
+
+	req = &ParseRequest{}
+	req = req.ReadFileFS(Dir(dir), "../../"+name)
+	require.NoError(t, req.err)
+	require.Equal(t, "foo", req.content)
+}
+
+func TestParseRequest_MaxSize(t *testing.T) {
+	tmpfile := tempFile(t)
+	defer os.RemoveAll(tmpfile.Name())
+
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			t.Error("large file was sent to the server")
+			return nil, errors.New("unexpected request")
+		},
+	})
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithMaxFileSize(2))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().ReadFile(tmpfile.Name()).Do()
+	terr, ok := parseErrCause(t, err).(*FileTooLargeError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, filepath.Base(tmpfile.Name()), terr.Filename)
+	require.Equal(t, int64(3), terr.Size)
+	require.Equal(t, int64(2), terr.MaxSize)
+
+	_, err = cli.NewParseRequest().ReadFile(tmpfile.Name()).Result()
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, filepath.Base(tmpfile.Name()), perr.Filename)
+	require.Equal(t, terr, perr.Err)
+
+	// the limit is also checked for the content set directly
+	_, err = cli.NewParseRequest().Content("foo").Do()
+	_, ok = parseErrCause(t, err).(*FileTooLargeError)
+	require.True(t, ok, "%T", err)
+
+	// the request limit overrides the client default
+	req := cli.NewParseRequest().MaxSize(-1).ReadFile(tmpfile.Name())
+	require.NoError(t, req.err)
+	require.Equal(t, "foo", req.content)
+}
+
+func TestParseRequest_Result(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{
+		version: "v2.16.0",
+		languages: []*protocol2.Manifest{
+			{Name: "Python", Language: "python", Version: &protocol2.Version{Version: "v2.9.0"}},
+		},
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			// the server detects the language
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: "python"}, nil
+		},
+	})
+	defer stop()
+
+	res, err := cli.NewParseRequest().Filename("foo").Content("x = 1").Mode(Semantic).Result()
+	require.NoError(t, err)
+	require.NotNil(t, res.Node)
+	require.Equal(t, "python", res.Language)
+	require.Equal(t, "foo", res.Filename)
+	require.Equal(t, Semantic, res.Mode)
+	require.Equal(t, "v2.16.0", res.ServerVersion)
+	require.NotNil(t, res.Driver)
+	require.Equal(t, "v2.9.0", res.Driver.Version)
+	require.True(t, res.ParseTime > 0)
+
+	_, lang, err := cli.NewParseRequest().Filename("foo").Content("x = 1").UAST()
+	require.NoError(t, err)
+	require.Equal(t, "python", lang)
+}
+
+func TestParseRequest_ResultError(t *testing.T) {
+	req := ParseRequest{}
+	res, err := req.ReadFile("NO_EXISTS").Language("go").Result()
+	require.Error(t, err)
+	require.Nil(t, res.Node)
+	require.Equal(t, "go", res.Language)
+}
+
 func tempFile(t *testing.T) *os.File {
 	content := []byte("foo")
 	tmpfile, err := ioutil.TempFile("", "example")
@@ -47,3 +160,156 @@ func tempFile(t *testing.T) *os.File {
 
 	return tmpfile
 }
+
+func TestParseRequest_Partial(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: "python", Errors: []*protocol2.ParseError{
+				{Text: "invalid syntax (<unknown>, line 2)"},
+				{Text: "foo.py:2:3: unexpected token"},
+				{Text: "unexpected EOF"},
+			}}, nil
+		},
+	})
+	defer stop()
+
+	const src = "x = 1\ny = (\n"
+
+	// ErrSyntax is returned by default
+	ast, _, err := cli.NewParseRequest().Content(src).UAST()
+	require.True(t, ErrSyntax.Is(parseErrCause(t, err)))
+	require.NotNil(t, ast)
+
+	ast, _, err = cli.NewParseRequest().Content(src).Partial().UAST()
+	require.NotNil(t, ast)
+	require.False(t, ErrSyntax.Is(parseErrCause(t, err)))
+	errs, ok := parseErrCause(t, err).(SyntaxErrors)
+	require.True(t, ok, "%T", err)
+	require.Len(t, errs, 3)
+
+	require.Equal(t, "invalid syntax (<unknown>, line 2)", errs[0].Message)
+	require.Equal(t, &uast.Position{Line: 2}, errs[0].Position)
+	require.Equal(t, &uast.Position{Line: 2, Col: 3, Offset: 8}, errs[1].Position)
+	require.Nil(t, errs[2].Position)
+
+	res, err := cli.NewParseRequest().Content(src).Result()
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.True(t, ErrSyntax.Is(perr.Err))
+	require.NotNil(t, res.Node)
+	require.Equal(t, errs, res.Errors)
+}
+
+func TestErrorPosition(t *testing.T) {
+	const src = "x = 1\ny = (\n"
+	cases := []struct {
+		msg string
+		exp *uast.Position
+	}{
+		{msg: "invalid syntax (<unknown>, line 2)", exp: &uast.Position{Line: 2}},
+		{msg: "line 2, column 3: unexpected token", exp: &uast.Position{Line: 2, Col: 3, Offset: 8}},
+		{msg: "foo.go:1:6: expected ';'", exp: &uast.Position{Line: 1, Col: 6, Offset: 5}},
+		{msg: "Unexpected token (2:5)", exp: &uast.Position{Line: 2, Col: 5, Offset: 10}},
+		{msg: "unexpected token [1, 2]", exp: &uast.Position{Line: 1, Col: 2, Offset: 1}},
+		// the column is out of the line, or the line is out of the file
+		{msg: "2:40: unexpected EOF", exp: &uast.Position{Line: 2, Col: 40}},
+		{msg: "10:1: unexpected EOF", exp: &uast.Position{Line: 10, Col: 1}},
+		// not a position
+		{msg: "timeout at 12:30:45", exp: nil},
+		{msg: "cannot connect to localhost:9432", exp: nil},
+		{msg: "dial tcp 10.0.0.1:9432: connection refused", exp: nil},
+		{msg: "unexpected EOF", exp: nil},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.exp, errorPosition(src, c.msg), c.msg)
+	}
+}
+
+func TestParseRequest_Encoding(t *testing.T) {
+	const src = "x = 1\ny = (\n"
+	var content string
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			content = req.Content
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: "python", Errors: []*protocol2.ParseError{
+				{Text: "foo.py:2:3: unexpected token"},
+			}}, nil
+		},
+	})
+	defer stop()
+
+	res, err := cli.NewParseRequest().Filename("foo.py").Content(bomUTF16LE + utf16le(src)).
+		DetectEncoding().Partial().Result()
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	errs, ok := perr.Err.(SyntaxErrors)
+	require.True(t, ok, "%T", perr.Err)
+	require.NotNil(t, res.Node)
+	require.Equal(t, src, content)
+	require.Equal(t, UTF16LE, res.Encoding)
+	// offsets are relative to the original content, including the BOM
+	require.Equal(t, &uast.Position{Line: 2, Col: 5, Offset: 18}, errs[0].Position)
+
+	// invalid UTF-8 is replaced, and the offsets are still mapped back
+	res, err = cli.NewParseRequest().Filename("foo.py").Content("x = '\xff\xfe'\ny = (\n").
+		Encoding(UTF8).Partial().Result()
+	perr, ok = err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, "x = '\uFFFD'\ny = (\n", content)
+	require.Equal(t, UTF8, res.Encoding)
+	require.Equal(t, &uast.Position{Line: 2, Col: 3, Offset: 11}, perr.Errors[0].Position)
+}
+
+func TestParseRequest_Filters(t *testing.T) {
+	var sent []string
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			sent = append(sent, req.Filename)
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}, nil
+		},
+	})
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithFilters(SkipBinary()))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	res, err := cli.NewParseRequest().Filename("foo.bin").Content("\x00\x01").Result()
+	require.NoError(t, err)
+	require.Equal(t, "binary content", res.Skipped)
+	require.Nil(t, res.Node)
+
+	// request filters are checked in addition to the client ones
+	ast, _, err := cli.NewParseRequest().Filename("foo.min.js").Content("x").Filters(SkipGenerated()).UAST()
+	require.True(t, IsSkipped(err), "%v", err)
+	require.Equal(t, &SkippedError{Filename: "foo.min.js", Reason: "generated file"}, err)
+	require.Nil(t, ast)
+
+	// filters are checked by Do as well
+	_, err = cli.NewParseRequest().Filename("foo.bin").Content("\x00\x01").Do()
+	require.True(t, IsSkipped(err), "%v", err)
+
+	res, err = cli.NewParseRequest().Filename("foo.py").Language("python").Content("x").Result()
+	require.NoError(t, err)
+	require.Empty(t, res.Skipped)
+	require.NotNil(t, res.Node)
+	require.Equal(t, []string{"foo.py"}, sent)
+}
+
+func TestParseRequest_PartialNoTree(t *testing.T) {
+	cli, stop := newMockClient(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			return &protocol2.ParseResponse{Errors: []*protocol2.ParseError{{Text: "line 1, column 4: oops"}}}, nil
+		},
+	})
+	defer stop()
+
+	res, err := cli.NewParseRequest().Content("foo bar").Partial().Result()
+	require.Nil(t, res.Node)
+	perr, ok := err.(*ParseError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, SyntaxErrors{
+		{Message: "line 1, column 4: oops", Position: &uast.Position{Line: 1, Col: 4, Offset: 3}},
+	}, perr.Errors)
+}
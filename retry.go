@@ -0,0 +1,150 @@
+package bblfsh
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultRetryAttempts       = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+	defaultRetryMultiplier     = 2
+	defaultRetryJitter         = 0.2
+)
+
+// RetryPolicy controls how parse requests are retried on transient failures.
+//
+// Only the gRPC errors with a code listed in Codes are retried. Syntax errors and
+// driver failures are never retried, since retrying would produce the same result.
+//
+// Zero fields are set to the values of DefaultRetryPolicy, except for MaxAttempts and Jitter,
+// so RetryPolicy{MaxAttempts: 5} retries the default codes up to 5 times.
+type RetryPolicy struct {
+	// MaxAttempts is the maximal number of attempts, including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is a delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is an upper bound for a delay between retries.
+	MaxBackoff time.Duration
+	// Multiplier is a factor the delay is multiplied by after each retry.
+	Multiplier float64
+	// Jitter is a fraction of the delay that is randomized, in the [0, 1] range.
+	Jitter float64
+	// Codes is a list of gRPC codes that are considered transient. Nil means the default codes,
+	// and an empty non-nil list disables retries.
+	Codes []codes.Code
+}
+
+// DefaultRetryPolicy returns a retry policy that retries Unavailable, ResourceExhausted
+// and DeadlineExceeded errors using an exponential backoff with jitter.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    defaultRetryAttempts,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Multiplier:     defaultRetryMultiplier,
+		Jitter:         defaultRetryJitter,
+		Codes: []codes.Code{
+			codes.Unavailable,
+			codes.ResourceExhausted,
+			codes.DeadlineExceeded,
+		},
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	def := DefaultRetryPolicy()
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = def.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = def.MaxBackoff
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = def.Multiplier
+	}
+	if p.Codes == nil {
+		p.Codes = def.Codes
+	}
+	return p
+}
+
+// NoRetry returns a retry policy that disables retries.
+func NoRetry() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// retryable checks if an error can be retried according to the policy.
+func (p *RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := status.FromError(err); !ok {
+		// not a gRPC error
+		return false
+	}
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns a delay before the given retry attempt (starting from 1).
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		if p.Multiplier > 1 {
+			d *= p.Multiplier
+		}
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if j := p.Jitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		d += d * j * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// do calls fnc until it succeeds, returns a non-retryable error, or the attempts are exhausted.
+//
+// A nil policy calls fnc exactly once.
+func (p *RetryPolicy) do(ctx context.Context, fnc func() error) error {
+	err := fnc()
+	if p == nil {
+		return err
+	}
+	for attempt := 1; attempt < p.MaxAttempts && p.retryable(err); attempt++ {
+		if ctx.Err() != nil {
+			// the caller gave up; retrying won't help
+			return err
+		}
+		t := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		err = fnc()
+	}
+	return err
+}
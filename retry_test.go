@@ -0,0 +1,188 @@
+package bblfsh
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testRetryPolicy() RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	return p
+}
+
+// failingServer returns a mock server that fails the first n requests with a given code.
+func failingServer(n int32, code codes.Code, calls *int32) *mockServer {
+	return &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			if atomic.AddInt32(calls, 1) <= n {
+				return nil, status.Error(code, "transient failure")
+			}
+			resp := &protocol2.ParseResponse{Uast: mockUAST, Language: req.Language}
+			if req.Content == "bad" {
+				resp.Errors = []*protocol2.ParseError{{Text: "unexpected token"}}
+			}
+			return resp, nil
+		},
+	}
+}
+
+func TestRetryPolicy_Client(t *testing.T) {
+	var calls int32
+	addr, stop := newMockServer(t, failingServer(2, codes.Unavailable, &calls))
+	defer stop()
+
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithRetryPolicy(testRetryPolicy()))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	var calls int32
+	addr, stop := newMockServer(t, failingServer(10, codes.ResourceExhausted, &calls))
+	defer stop()
+
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithRetryPolicy(testRetryPolicy()))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").Do()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_Defaults(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5}.withDefaults()
+	def := DefaultRetryPolicy()
+	require.Equal(t, 5, p.MaxAttempts)
+	require.Equal(t, def.Codes, p.Codes)
+	require.Equal(t, def.InitialBackoff, p.InitialBackoff)
+	require.Equal(t, def.MaxBackoff, p.MaxBackoff)
+	require.Equal(t, def.Multiplier, p.Multiplier)
+	require.Equal(t, float64(0), p.Jitter)
+
+	// an empty list of codes disables retries
+	p = RetryPolicy{MaxAttempts: 5, Codes: []codes.Code{}}.withDefaults()
+	require.Empty(t, p.Codes)
+
+	var calls int32
+	addr, stop := newMockServer(t, failingServer(2, codes.Unavailable, &calls))
+	defer stop()
+
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3, InitialBackoff: time.Millisecond,
+	}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_NotRetryable(t *testing.T) {
+	var calls int32
+	addr, stop := newMockServer(t, failingServer(1, codes.Internal, &calls))
+	defer stop()
+
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithRetryPolicy(testRetryPolicy()))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, _, err = cli.NewParseRequest().Language("python").Content("import foo").UAST()
+	require.True(t, ErrDriverFailure.Is(parseErrCause(t, err)), "%v", err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// syntax errors are never retried
+	_, _, err = cli.NewParseRequest().Language("python").Content("bad").UAST()
+	require.True(t, ErrSyntax.Is(parseErrCause(t, err)), "%v", err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_Request(t *testing.T) {
+	var calls int32
+	addr, stop := newMockServer(t, failingServer(1, codes.Unavailable, &calls))
+	defer stop()
+
+	// no retries by default
+	cli, err := NewClientContext(context.Background(), addr)
+	require.NoError(t, err)
+	defer cli.Close()
+
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").Do()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").
+		RetryPolicy(testRetryPolicy()).Do()
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// per-request policy overrides the client one
+	policy := testRetryPolicy()
+	cli.opts.retry = &policy
+	atomic.StoreInt32(&calls, 0)
+	_, err = cli.NewParseRequest().Language("python").Content("import foo").
+		RetryPolicy(NoRetry()).Do()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryPolicy_Context(t *testing.T) {
+	p := testRetryPolicy()
+	p.MaxAttempts = 100
+	p.InitialBackoff = time.Hour
+	p.MaxBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.do(ctx, func() error {
+			calls++
+			return status.Error(codes.Unavailable, "transient failure")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry loop ignores context")
+	}
+	require.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	require.Equal(t, 100*time.Millisecond, p.backoff(1))
+	require.Equal(t, 200*time.Millisecond, p.backoff(2))
+	require.Equal(t, 800*time.Millisecond, p.backoff(4))
+	require.Equal(t, time.Second, p.backoff(5))
+	require.Equal(t, time.Second, p.backoff(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		require.True(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond, "%v", d)
+	}
+}
@@ -0,0 +1,90 @@
+package bblfsh
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Timeouts configures deadlines of individual RPCs sent by the client.
+//
+// Each attempt of a retried request gets its own deadline. The deadline of the request
+// context still applies to the whole request, including retries.
+type Timeouts struct {
+	// RPC is the timeout of each RPC. Zero means no timeout.
+	RPC time.Duration
+	// PerMB is added to the timeout of parse requests for each megabyte of content,
+	// since large files take longer to parse. It has no effect if RPC is zero.
+	PerMB time.Duration
+	// Max limits the timeout of parse requests scaled by the content size. Zero means no limit.
+	Max time.Duration
+}
+
+// parse returns a timeout for a parse request with a given content size, or zero if there is no timeout.
+func (t Timeouts) parse(size int) time.Duration {
+	d := t.RPC
+	if d <= 0 {
+		// scaling alone would give tiny deadlines to small files
+		return 0
+	}
+	if t.PerMB > 0 {
+		d += time.Duration(float64(t.PerMB) * float64(size) / (1 << 20))
+	}
+	if t.Max > 0 && d > t.Max {
+		d = t.Max
+	}
+	return d
+}
+
+// TimeoutSource tells which deadline was exceeded by a request.
+type TimeoutSource int
+
+const (
+	// NoTimeout means that the request did not time out.
+	NoTimeout TimeoutSource = iota
+	// ContextTimeout means that the deadline of the request context was exceeded.
+	ContextTimeout
+	// RPCTimeout means that the RPC timeout set by WithTimeouts or ParseRequest.Timeout was exceeded.
+	RPCTimeout
+	// ServerTimeout means that the server gave up on the request, for example because
+	// the driver did not parse the file in time.
+	ServerTimeout
+)
+
+func (s TimeoutSource) String() string {
+	switch s {
+	case NoTimeout:
+		return "none"
+	case ContextTimeout:
+		return "context"
+	case RPCTimeout:
+		return "rpc"
+	case ServerTimeout:
+		return "server"
+	}
+	return "unknown"
+}
+
+// withTimeout returns a context for a single RPC with a given timeout. Zero means no timeout.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// timeoutSource determines which deadline caused the RPC error, if any.
+// Ctx is the request context, and rctx is the context of the RPC derived from it.
+func timeoutSource(ctx, rctx context.Context, err error) TimeoutSource {
+	if errorCode(err) != codes.DeadlineExceeded {
+		return NoTimeout
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return ContextTimeout
+	case rctx.Err() == context.DeadlineExceeded:
+		return RPCTimeout
+	}
+	return ServerTimeout
+}
@@ -0,0 +1,96 @@
+package bblfsh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTimeouts_Parse(t *testing.T) {
+	cases := []struct {
+		name string
+		tm   Timeouts
+		size int
+		exp  time.Duration
+	}{
+		{name: "empty", tm: Timeouts{RPC: time.Second, PerMB: 2 * time.Second}, size: 0, exp: time.Second},
+		{name: "scaled", tm: Timeouts{RPC: time.Second, PerMB: 2 * time.Second}, size: 1 << 19, exp: 2 * time.Second},
+		{name: "max", tm: Timeouts{RPC: time.Second, PerMB: 2 * time.Second, Max: 5 * time.Second}, size: 10 << 20, exp: 5 * time.Second},
+		{name: "none", tm: Timeouts{}, size: 1 << 20, exp: 0},
+		{name: "per mb only", tm: Timeouts{PerMB: 10 * time.Second}, size: 100, exp: 0},
+		{name: "per mb only empty", tm: Timeouts{PerMB: 10 * time.Second}, size: 0, exp: 0},
+		{name: "max only", tm: Timeouts{Max: time.Second}, size: 1 << 20, exp: 0},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.exp, c.tm.parse(c.size))
+		})
+	}
+}
+
+func TestParseRequest_Timeout(t *testing.T) {
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			if req.Content == "server" {
+				return nil, status.Error(codes.DeadlineExceeded, "driver timeout")
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithTimeouts(Timeouts{RPC: 50 * time.Millisecond}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	timeout := func(req *ParseRequest) *ParseError {
+		_, err := req.Language("python").Result()
+		perr, ok := err.(*ParseError)
+		require.True(t, ok, "%T", err)
+		require.Equal(t, codes.DeadlineExceeded, perr.Code)
+		return perr
+	}
+
+	start := time.Now()
+	perr := timeout(cli.NewParseRequest().Content("x"))
+	require.Equal(t, RPCTimeout, perr.Timeout)
+	require.True(t, perr.Retryable)
+	require.True(t, time.Since(start) < 2*time.Second)
+
+	perr = timeout(cli.NewParseRequest().Content("server"))
+	require.Equal(t, ServerTimeout, perr.Timeout)
+	require.True(t, perr.Retryable)
+
+	// the request context expires before the RPC timeout
+	rctx, rcancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer rcancel()
+	perr = timeout(cli.NewParseRequest().Context(rctx).Timeout(-1).Content("x"))
+	require.Equal(t, ContextTimeout, perr.Timeout)
+	require.False(t, perr.Retryable)
+}
+
+func TestServerError_Timeout(t *testing.T) {
+	srv := &mockServer{}
+	addr, stop := newMockServer(t, srv)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cli, err := NewClientWithOptionsContext(ctx, addr, WithTimeouts(Timeouts{RPC: time.Second}))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// the client requests the version when connecting
+	srv.hostErr = status.Error(codes.DeadlineExceeded, "timeout")
+	_, err = cli.NewVersionRequest().Do()
+	serr, ok := err.(*ServerError)
+	require.True(t, ok, "%T", err)
+	require.Equal(t, ServerTimeout, serr.Timeout)
+}
@@ -0,0 +1,56 @@
+package bblfsh
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig configures TLS for connections to bblfshd.
+type TLSConfig struct {
+	// CAFile is a path to a PEM-encoded CA bundle used to verify the server certificate.
+	// System roots are used if it's empty.
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	// CertFile is a path to a PEM-encoded client certificate. Setting it enables mutual TLS.
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	// KeyFile is a path to a PEM-encoded private key of the client certificate.
+	KeyFile string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	// ServerName overrides the host name used to verify the server certificate.
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	// InsecureSkipVerify disables verification of the server certificate.
+	// It should only be used for development.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+}
+
+// Config loads certificates and returns a TLS configuration for the client.
+func (c TLSConfig) Config() (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		data, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", c.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+	switch {
+	case c.CertFile != "" && c.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	case c.CertFile != "":
+		return nil, fmt.Errorf("client certificate is set, but the key is missing")
+	case c.KeyFile != "":
+		return nil, fmt.Errorf("client key is set, but the certificate is missing")
+	}
+	return conf, nil
+}
@@ -0,0 +1,216 @@
+package bblfsh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const testTLSServerName = "bblfsh.test"
+
+// testCerts contains paths to the certificates generated for tests.
+type testCerts struct {
+	dir        string
+	CA         string
+	ServerCert string
+	ServerKey  string
+	ClientCert string
+	ClientKey  string
+}
+
+func genCert(t testing.TB, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	kder, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	return cert, key, certPEM, keyPEM
+}
+
+// newTestCerts generates a CA, a server and a client certificates in a temporary directory.
+func newTestCerts(t testing.TB) *testCerts {
+	dir, err := ioutil.TempDir("", "bblfsh-tls")
+	require.NoError(t, err)
+
+	now := time.Now()
+	ca, caKey, caPEM, _ := genCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bblfsh test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	_, _, srvPEM, srvKeyPEM := genCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: testTLSServerName},
+		DNSNames:     []string{testTLSServerName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, cliPEM, cliKeyPEM := genCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	c := &testCerts{
+		dir:        dir,
+		CA:         filepath.Join(dir, "ca.pem"),
+		ServerCert: filepath.Join(dir, "server.pem"),
+		ServerKey:  filepath.Join(dir, "server-key.pem"),
+		ClientCert: filepath.Join(dir, "client.pem"),
+		ClientKey:  filepath.Join(dir, "client-key.pem"),
+	}
+	for path, data := range map[string][]byte{
+		c.CA:         caPEM,
+		c.ServerCert: srvPEM,
+		c.ServerKey:  srvKeyPEM,
+		c.ClientCert: cliPEM,
+		c.ClientKey:  cliKeyPEM,
+	} {
+		require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	}
+	return c
+}
+
+func (c *testCerts) Close() {
+	os.RemoveAll(c.dir)
+}
+
+// newTLSMockServer starts a mock server that requires client certificates.
+func newTLSMockServer(t testing.TB, certs *testCerts) (string, func()) {
+	cert, err := tls.LoadX509KeyPair(certs.ServerCert, certs.ServerKey)
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(certs.CA)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(data))
+
+	return newMockServer(t, &mockServer{}, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+}
+
+func TestTLS(t *testing.T) {
+	certs := newTestCerts(t)
+	defer certs.Close()
+
+	addr, stop := newTLSMockServer(t, certs)
+	defer stop()
+	_, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	conf := TLSConfig{
+		CAFile:     certs.CA,
+		CertFile:   certs.ClientCert,
+		KeyFile:    certs.ClientKey,
+		ServerName: testTLSServerName,
+	}
+	for _, c := range []struct {
+		name     string
+		endpoint string
+		lang     string
+	}{
+		{name: "single", endpoint: addr, lang: "python"},
+		{name: "mapping", endpoint: "python=" + addr + ",go=" + addr, lang: "python"},
+		// language is used as the last octet of the IP address
+		{name: "template", endpoint: "127.0.0.%s:" + port, lang: "1"},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			cli, err := NewClientWithOptionsContext(ctx, c.endpoint, WithTLS(conf))
+			require.NoError(t, err)
+			defer cli.Close()
+
+			resp, err := cli.NewParseRequest().Context(ctx).Language(c.lang).Content("foo").Do()
+			require.NoError(t, err)
+			require.Equal(t, c.lang, resp.Language)
+		})
+	}
+}
+
+func TestTLS_Untrusted(t *testing.T) {
+	certs := newTestCerts(t)
+	defer certs.Close()
+
+	addr, stop := newTLSMockServer(t, certs)
+	defer stop()
+
+	for _, conf := range []TLSConfig{
+		// no CA
+		{CertFile: certs.ClientCert, KeyFile: certs.ClientKey, ServerName: testTLSServerName},
+		// wrong server name
+		{CAFile: certs.CA, CertFile: certs.ClientCert, KeyFile: certs.ClientKey},
+		// insecure
+		{},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		_, err := NewClientWithOptionsContext(ctx, addr, WithTLS(conf))
+		cancel()
+		require.Error(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := NewClientWithOptionsContext(ctx, addr, WithTLS(TLSConfig{
+		CertFile:           certs.ClientCert,
+		KeyFile:            certs.ClientKey,
+		InsecureSkipVerify: true,
+	}))
+	require.NoError(t, err)
+}
+
+func TestTLSConfig_Errors(t *testing.T) {
+	certs := newTestCerts(t)
+	defer certs.Close()
+
+	_, err := TLSConfig{CAFile: "NO_EXISTS"}.Config()
+	require.Error(t, err)
+
+	_, err = TLSConfig{CAFile: certs.ClientKey}.Config()
+	require.Error(t, err)
+
+	_, err = TLSConfig{CertFile: certs.ClientCert}.Config()
+	require.Error(t, err)
+
+	_, err = NewClientWithOptionsContext(context.Background(), "localhost:0", WithTLS(TLSConfig{KeyFile: certs.ClientKey}))
+	require.Error(t, err)
+}
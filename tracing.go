@@ -0,0 +1,187 @@
+package bblfsh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Span names used by the client.
+const (
+	SpanParse              = "bblfsh.Parse"
+	SpanVersion            = "bblfsh.Version"
+	SpanSupportedLanguages = "bblfsh.SupportedLanguages"
+)
+
+// Span attributes set by the client.
+const (
+	AttrLanguage      = "bblfsh.language"
+	AttrFilename      = "bblfsh.filename"
+	AttrMode          = "bblfsh.mode"
+	AttrContentLength = "bblfsh.content_length"
+	AttrEndpoint      = "bblfsh.endpoint"
+	// AttrStatus is the gRPC status code of the request.
+	AttrStatus = "bblfsh.status"
+)
+
+// Tracer creates spans for client calls and propagates the trace context to the server.
+// See WithTracer.
+type Tracer interface {
+	// Start starts a new span, which is a child of the span in the context, if any.
+	// The returned context carries the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject writes the trace context of the span in ctx by calling set for each key.
+	// The keys are sent to the server as gRPC metadata.
+	Inject(ctx context.Context, set func(key, value string))
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	SetAttribute(key string, value interface{})
+	// SetError records the error the operation failed with.
+	SetError(err error)
+	// End finishes the span.
+	End()
+}
+
+// startSpan starts a span and attaches its trace context to the outgoing gRPC metadata.
+func startSpan(ctx context.Context, t Tracer, name string) (context.Context, Span) {
+	ctx, span := t.Start(ctx, name)
+	var kv []string
+	t.Inject(ctx, func(key, value string) {
+		kv = append(kv, key, value)
+	})
+	if len(kv) != 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+	}
+	return ctx, span
+}
+
+// endSpan sets the status of the request and finishes the span.
+func endSpan(span Span, err error) {
+	span.SetAttribute(AttrStatus, errorCode(err).String())
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// setPeer sets the endpoint attribute from the peer of the last RPC, if known.
+func setPeer(span Span, p *peer.Peer) {
+	if p.Addr != nil {
+		span.SetAttribute(AttrEndpoint, p.Addr.String())
+	}
+}
+
+// traceparentKey is a metadata key used by MemoryTracer, as defined by W3C Trace Context.
+const traceparentKey = "traceparent"
+
+// MemoryTracer is a Tracer that keeps finished spans in memory. It is mostly useful for tests.
+//
+// The trace context is propagated with the W3C "traceparent" header.
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+// NewMemoryTracer creates an in-memory tracer.
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+// MemorySpan is a span recorded by MemoryTracer.
+type MemorySpan struct {
+	Name string
+	// TraceID, SpanID and ParentID are hex-encoded identifiers. ParentID is empty for root spans.
+	TraceID  string
+	SpanID   string
+	ParentID string
+	// Attributes of the span.
+	Attributes map[string]interface{}
+	// Err is the error recorded with SetError.
+	Err error
+	// StartTime and EndTime are the times the span was started and finished.
+	StartTime time.Time
+	EndTime   time.Time
+
+	tracer *MemoryTracer
+}
+
+type memorySpanKey struct{}
+
+// Start implements Tracer.
+func (t *MemoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &MemorySpan{
+		Name:       name,
+		SpanID:     randomID(8),
+		Attributes: make(map[string]interface{}),
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+	if parent, ok := ctx.Value(memorySpanKey{}).(*MemorySpan); ok {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		s.TraceID = randomID(16)
+	}
+	return context.WithValue(ctx, memorySpanKey{}, s), s
+}
+
+// Inject implements Tracer.
+func (t *MemoryTracer) Inject(ctx context.Context, set func(key, value string)) {
+	if s, ok := ctx.Value(memorySpanKey{}).(*MemorySpan); ok {
+		set(traceparentKey, s.Traceparent())
+	}
+}
+
+// Spans returns finished spans in the order they were finished.
+func (t *MemoryTracer) Spans() []*MemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*MemorySpan(nil), t.spans...)
+}
+
+// Reset removes all the recorded spans.
+func (t *MemoryTracer) Reset() {
+	t.mu.Lock()
+	t.spans = nil
+	t.mu.Unlock()
+}
+
+// SetAttribute implements Span.
+func (s *MemorySpan) SetAttribute(key string, value interface{}) {
+	s.Attributes[key] = value
+}
+
+// SetError implements Span.
+func (s *MemorySpan) SetError(err error) {
+	s.Err = err
+}
+
+// End implements Span.
+func (s *MemorySpan) End() {
+	s.EndTime = time.Now()
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// Traceparent returns the W3C trace context header of the span.
+func (s *MemorySpan) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", s.TraceID, s.SpanID)
+}
+
+// randomID generates a random hex-encoded identifier of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,73 @@
+package bblfsh
+
+import (
+	"context"
+	"testing"
+
+	protocol2 "github.com/bblfsh/sdk/v3/protocol"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTracing(t *testing.T) {
+	var traceparent []string
+	addr, stop := newMockServer(t, &mockServer{
+		parse: func(ctx context.Context, req *protocol2.ParseRequest) (*protocol2.ParseResponse, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			traceparent = md.Get(traceparentKey)
+			if req.Content == "down" {
+				return nil, status.Error(codes.Unavailable, "driver is down")
+			}
+			return &protocol2.ParseResponse{Uast: mockUAST, Language: "python"}, nil
+		},
+	})
+	defer stop()
+
+	tr := NewMemoryTracer()
+	cli, err := NewClientWithOptionsContext(context.Background(), addr, WithTracer(tr))
+	require.NoError(t, err)
+	defer cli.Close()
+
+	// parent span of the application
+	ctx, parent := tr.Start(context.Background(), "app")
+
+	_, _, err = cli.NewParseRequest().Context(ctx).Filename("foo.py").Content("import foo").Mode(Semantic).UAST()
+	require.NoError(t, err)
+
+	spans := tr.Spans()
+	require.Len(t, spans, 1)
+	s := spans[0]
+	require.Equal(t, SpanParse, s.Name)
+	require.Equal(t, parent.(*MemorySpan).TraceID, s.TraceID)
+	require.Equal(t, parent.(*MemorySpan).SpanID, s.ParentID)
+	require.Equal(t, []string{s.Traceparent()}, traceparent)
+	require.Equal(t, "python", s.Attributes[AttrLanguage])
+	require.Equal(t, "foo.py", s.Attributes[AttrFilename])
+	require.Equal(t, Semantic.String(), s.Attributes[AttrMode])
+	require.Equal(t, len("import foo"), s.Attributes[AttrContentLength])
+	require.Equal(t, addr, s.Attributes[AttrEndpoint])
+	require.Equal(t, codes.OK.String(), s.Attributes[AttrStatus])
+	require.Nil(t, s.Err)
+
+	tr.Reset()
+	_, err = cli.NewParseRequest().Language("python").Content("down").Do()
+	require.Error(t, err)
+	spans = tr.Spans()
+	require.Len(t, spans, 1)
+	require.Empty(t, spans[0].ParentID)
+	require.Equal(t, codes.Unavailable.String(), spans[0].Attributes[AttrStatus])
+	require.Error(t, spans[0].Err)
+
+	tr.Reset()
+	_, err = cli.NewVersionRequest().Do()
+	require.NoError(t, err)
+	_, err = cli.NewSupportedLanguagesRequest().Do()
+	require.NoError(t, err)
+	spans = tr.Spans()
+	require.Len(t, spans, 2)
+	require.Equal(t, SpanVersion, spans[0].Name)
+	require.Equal(t, SpanSupportedLanguages, spans[1].Name)
+	require.Equal(t, addr, spans[0].Attributes[AttrEndpoint])
+}